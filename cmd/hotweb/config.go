@@ -0,0 +1,194 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/progrium/hotweb/pkg/hotweb"
+)
+
+var ConfigFilenames = []string{"hotweb.json", "hotweb.toml"}
+
+// Config is the format of a hotweb.json or hotweb.toml file in the
+// served directory. Relative paths are relative to that directory.
+type Config struct {
+	Port          string            `json:"port" toml:"port"`
+	ServeRoot     string            `json:"serveRoot" toml:"serveRoot"`
+	Prefix        string            `json:"prefix" toml:"prefix"`
+	Fallback      string            `json:"fallback" toml:"fallback"`
+	Templates     bool              `json:"templates" toml:"templates"`
+	ReloadOnError bool              `json:"reloadOnError" toml:"reloadOnError"`
+	PushSource    bool              `json:"pushSource" toml:"pushSource"`
+	Warmup        int               `json:"warmup" toml:"warmup"`
+	CacheDir      string            `json:"cacheDir" toml:"cacheDir"`
+	CacheSize     int64             `json:"cacheSize" toml:"cacheSize"` // megabytes
+	LazyWatch     bool              `json:"lazyWatch" toml:"lazyWatch"`
+	JsxFactory    string            `json:"jsxFactory" toml:"jsxFactory"`
+	InternalPath  string            `json:"internalPath" toml:"internalPath"`
+	ReloadExport  string            `json:"reloadExport" toml:"reloadExport"`
+	WatchInterval string            `json:"watchInterval" toml:"watchInterval"`
+	IgnoreDirs    []string          `json:"ignoreDirs" toml:"ignoreDirs"`
+	Mounts        []MountConfig     `json:"mounts" toml:"mounts"`
+	Proxies       []ProxyConfig     `json:"proxies" toml:"proxies"`
+	Headers       map[string]string `json:"headers" toml:"headers"`
+	TLS           bool              `json:"tls" toml:"tls"`
+	CertFile      string            `json:"certFile" toml:"certFile"`
+	KeyFile       string            `json:"keyFile" toml:"keyFile"`
+}
+
+type MountConfig struct {
+	Dir  string `json:"dir" toml:"dir"`
+	Path string `json:"path" toml:"path"`
+}
+
+type ProxyConfig struct {
+	Path   string `json:"path" toml:"path"`
+	Target string `json:"target" toml:"target"`
+}
+
+// parseProxies parses comma delimited path=url pairs.
+func parseProxies(s string) ([]ProxyConfig, error) {
+	var proxies []ProxyConfig
+	for _, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid proxy %q, expected path=url", pair)
+		}
+		proxies = append(proxies, ProxyConfig{Path: parts[0], Target: parts[1]})
+	}
+	return proxies, nil
+}
+
+// loadConfig reads the first config file found in dir. A missing
+// config file is not an error.
+func loadConfig(dir string) (Config, error) {
+	var cfg Config
+	for _, name := range ConfigFilenames {
+		filename := filepath.Join(dir, name)
+		b, err := ioutil.ReadFile(filename)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return cfg, err
+		}
+		switch filepath.Ext(name) {
+		case ".json":
+			err = json.Unmarshal(b, &cfg)
+		case ".toml":
+			err = toml.Unmarshal(b, &cfg)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("%s: %v", filename, err)
+		}
+		return cfg, nil
+	}
+	return cfg, nil
+}
+
+// overrideConfig sets the values of flags set on the command line in
+// cfg, as they take precedence over the config file.
+func overrideConfig(cfg Config, flags *flag.FlagSet) (Config, error) {
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		value := f.Value.String()
+		switch f.Name {
+		case "port":
+			cfg.Port = value
+		case "ignore":
+			cfg.IgnoreDirs = strings.Split(value, ",")
+		case "fallback":
+			cfg.Fallback = value
+		case "proxy":
+			cfg.Proxies, err = parseProxies(value)
+		case "tls":
+			cfg.TLS = value == "true"
+		case "cert":
+			cfg.CertFile = value
+		case "key":
+			cfg.KeyFile = value
+		}
+	})
+	return cfg, err
+}
+
+// HotwebConfig converts to a hotweb.Config, resolving relative paths
+// against dir.
+func (c Config) HotwebConfig(dir string) (hotweb.Config, error) {
+	cfg := hotweb.Config{
+		ServeRoot:     resolvePath(dir, c.ServeRoot),
+		Prefix:        c.Prefix,
+		Fallback:      c.Fallback,
+		Templates:     c.Templates,
+		ReloadOnError: c.ReloadOnError,
+		PushSource:    c.PushSource,
+		Warmup:        c.Warmup,
+		CacheSize:     c.CacheSize << 20,
+		LazyWatch:     c.LazyWatch,
+		JsxFactory:    c.JsxFactory,
+		InternalPath:  c.InternalPath,
+		ReloadExport:  c.ReloadExport,
+		IgnoreDirs:    c.IgnoreDirs,
+	}
+	if c.CacheDir != "" {
+		cfg.CacheDir = resolvePath(dir, c.CacheDir)
+	}
+	if c.WatchInterval != "" {
+		interval, err := time.ParseDuration(c.WatchInterval)
+		if err != nil {
+			return cfg, fmt.Errorf("watchInterval: %v", err)
+		}
+		cfg.WatchInterval = interval
+	}
+	for _, mount := range c.Mounts {
+		cfg.Mounts = append(cfg.Mounts, hotweb.Mount{
+			Dir:  resolvePath(dir, mount.Dir),
+			Path: mount.Path,
+		})
+	}
+	for _, proxy := range c.Proxies {
+		target, err := url.Parse(proxy.Target)
+		if err != nil {
+			return cfg, fmt.Errorf("proxies: %v", err)
+		}
+		if target.Scheme == "" || target.Host == "" {
+			return cfg, fmt.Errorf("proxies: target %q is not an absolute URL", proxy.Target)
+		}
+		cfg.Proxies = append(cfg.Proxies, hotweb.Proxy{
+			Path:   proxy.Path,
+			Target: proxy.Target,
+		})
+	}
+	return cfg, nil
+}
+
+func resolvePath(dir, path string) string {
+	if filepath.IsAbs(path) {
+		return filepath.Clean(path)
+	}
+	return filepath.Join(dir, path)
+}
+
+func withHeaders(h http.Handler, headers map[string]string) http.Handler {
+	if len(headers) == 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for k, v := range headers {
+			w.Header().Set(k, v)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"flag"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConfig(t *testing.T) {
+	writeConfig := func(t *testing.T, name, src string) string {
+		dir, err := ioutil.TempDir("", "hotweb")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	t.Run("json config", func(t *testing.T) {
+		dir := writeConfig(t, "hotweb.json", `{
+			"port": "3000",
+			"serveRoot": "public",
+			"mounts": [{"dir": "../shared", "path": "/shared"}],
+			"proxies": [{"path": "/api", "target": "http://localhost:4000"}],
+			"watchInterval": "1s"
+		}`)
+		defer os.RemoveAll(dir)
+
+		cfg, err := loadConfig(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Port != "3000" {
+			t.Errorf("got port %q want 3000", cfg.Port)
+		}
+		hcfg, err := cfg.HotwebConfig(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hcfg.ServeRoot != filepath.Join(dir, "public") {
+			t.Errorf("got serve root %q want it resolved against %q", hcfg.ServeRoot, dir)
+		}
+		if len(hcfg.Mounts) != 1 || hcfg.Mounts[0].Dir != filepath.Join(filepath.Dir(dir), "shared") {
+			t.Errorf("got mounts %v want resolved mount", hcfg.Mounts)
+		}
+		if len(hcfg.Proxies) != 1 || hcfg.Proxies[0].Target != "http://localhost:4000" {
+			t.Errorf("got proxies %v want /api proxy", hcfg.Proxies)
+		}
+		if hcfg.WatchInterval.String() != "1s" {
+			t.Errorf("got watch interval %v want 1s", hcfg.WatchInterval)
+		}
+	})
+
+	t.Run("toml config", func(t *testing.T) {
+		dir := writeConfig(t, "hotweb.toml", `
+port = "3000"
+fallback = "/index.html"
+
+[[proxies]]
+path = "/api"
+target = "http://localhost:4000"
+
+[headers]
+Cache-Control = "no-store"
+`)
+		defer os.RemoveAll(dir)
+
+		cfg, err := loadConfig(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Port != "3000" || cfg.Fallback != "/index.html" {
+			t.Errorf("got port %q fallback %q", cfg.Port, cfg.Fallback)
+		}
+		if len(cfg.Proxies) != 1 || cfg.Proxies[0].Path != "/api" {
+			t.Errorf("got proxies %v want /api proxy", cfg.Proxies)
+		}
+		if cfg.Headers["Cache-Control"] != "no-store" {
+			t.Errorf("got headers %v want Cache-Control", cfg.Headers)
+		}
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		dir := writeConfig(t, "hotweb.json", `{"port": 3000}`)
+		defer os.RemoveAll(dir)
+
+		if _, err := loadConfig(dir); err == nil {
+			t.Error("got no error for invalid config")
+		}
+	})
+
+	t.Run("bad proxy", func(t *testing.T) {
+		if _, err := parseProxies("/api"); err == nil {
+			t.Error("got no error for proxy without url")
+		}
+		proxies, err := parseProxies("/api=http://localhost:4000,/ws=http://localhost:5000")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(proxies) != 2 || proxies[1].Path != "/ws" || proxies[1].Target != "http://localhost:5000" {
+			t.Errorf("got %v want two proxies", proxies)
+		}
+
+		for _, target := range []string{"localhost:4000", "/api", "http://%zz"} {
+			cfg := Config{Proxies: []ProxyConfig{{Path: "/api", Target: target}}}
+			if _, err := cfg.HotwebConfig("."); err == nil {
+				t.Errorf("got no error for proxy target %q", target)
+			}
+		}
+	})
+
+	t.Run("headers", func(t *testing.T) {
+		h := withHeaders(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("Cache-Control", "max-age=60")
+		}), map[string]string{"Cache-Control": "no-store", "X-Frame-Options": "DENY"})
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+		if got := rr.Header().Get("X-Frame-Options"); got != "DENY" {
+			t.Errorf("got X-Frame-Options %q want DENY", got)
+		}
+		if got := rr.Header().Get("Content-Type"); got != "text/plain" {
+			t.Errorf("got Content-Type %q want handler header kept", got)
+		}
+		if got := rr.Header().Get("Cache-Control"); got != "max-age=60" {
+			t.Errorf("got Cache-Control %q want handler to override config", got)
+		}
+	})
+
+	t.Run("flags override config", func(t *testing.T) {
+		flags := flag.NewFlagSet("hotweb", flag.ContinueOnError)
+		flags.String("port", "8080", "")
+		flags.String("fallback", "", "")
+		flags.String("proxy", "", "")
+		flags.Bool("tls", false, "")
+		if err := flags.Parse([]string{"-port", "9000", "-tls", "-proxy", "/api=http://localhost:5000"}); err != nil {
+			t.Fatal(err)
+		}
+
+		cfg, err := overrideConfig(Config{
+			Port:     "3000",
+			Fallback: "/index.html",
+			Proxies:  []ProxyConfig{{Path: "/api", Target: "http://localhost:4000"}},
+		}, flags)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cfg.Port != "9000" || !cfg.TLS {
+			t.Errorf("got port %q tls %v want flag values", cfg.Port, cfg.TLS)
+		}
+		if cfg.Fallback != "/index.html" {
+			t.Errorf("got fallback %q want config value kept", cfg.Fallback)
+		}
+		if len(cfg.Proxies) != 1 || cfg.Proxies[0].Target != "http://localhost:5000" {
+			t.Errorf("got proxies %v want flag proxy", cfg.Proxies)
+		}
+
+		flags.Set("proxy", "/api")
+		if _, err := overrideConfig(Config{}, flags); err == nil {
+			t.Error("got no error for bad proxy flag")
+		}
+	})
+}
@@ -2,28 +2,39 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strings"
 
 	"github.com/gorilla/handlers"
+	"github.com/progrium/hotweb/pkg/devcert"
 	"github.com/progrium/hotweb/pkg/hotweb"
 	"github.com/skratchdot/open-golang/open"
 	"github.com/spf13/afero"
 )
 
 var (
-	Port   string
-	Dir    string
-	Ignore string
+	Port     string
+	Dir      string
+	Ignore   string
+	Proxy    string
+	Fallback string
+	TLS      bool
+	CertFile string
+	KeyFile  string
 )
 
 func init() {
 	flag.StringVar(&Port, "port", "8080", "port to listen on")
 	flag.StringVar(&Dir, "dir", ".", "directory to serve")
 	flag.StringVar(&Ignore, "ignore", "", "directories to not proxy for, comma delimited")
+	flag.StringVar(&Fallback, "fallback", "", "document to serve for client-side routes, eg /index.html")
+	flag.StringVar(&Proxy, "proxy", "", "path=url pairs to forward to upstream servers, comma delimited")
+	flag.BoolVar(&TLS, "tls", false, "serve HTTPS, using a generated local CA unless -cert and -key are given")
+	flag.StringVar(&CertFile, "cert", "", "certificate file to serve HTTPS with")
+	flag.StringVar(&KeyFile, "key", "", "key file to serve HTTPS with")
 }
 
 func main() {
@@ -37,23 +48,66 @@ func main() {
 		}
 	}
 
-	fs := afero.NewOsFs()
-	cfg := hotweb.Config{
-		Filesystem: fs,
-		ServeRoot:  filepath.Clean(Dir),
+	Dir = filepath.Clean(Dir)
+	fileCfg, err := loadConfig(Dir)
+	if err != nil {
+		log.Fatal(err)
 	}
+
+	fileCfg, err = overrideConfig(fileCfg, flag.CommandLine)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if (fileCfg.CertFile == "") != (fileCfg.KeyFile == "") {
+		fmt.Fprintln(os.Stderr, "-cert and -key must be given together")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if fileCfg.Port == "" {
+		fileCfg.Port = Port
+	}
+
+	cfg, err := fileCfg.HotwebConfig(Dir)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.Filesystem = afero.NewOsFs()
 	hw := hotweb.New(cfg)
-	hw.IgnoreDirs = strings.Split(Ignore, ",")
 
 	go func() {
-		log.Printf("watching %#v\n", Dir)
+		log.Printf("watching %#v\n", cfg.ServeRoot)
 		log.Fatal(hw.Watch())
 	}()
 
-	listenAddr := "0.0.0.0:" + Port
-	url := "http://" + listenAddr
+	listenAddr := "0.0.0.0:" + fileCfg.Port
+	handler := handlers.LoggingHandler(os.Stdout, withHeaders(hw, fileCfg.Headers))
+	if !fileCfg.TLS {
+		url := "http://" + listenAddr
+		open.Start(url)
+
+		log.Printf("serving at %s\n", url)
+		log.Fatal(http.ListenAndServe(listenAddr, handler))
+	}
+
+	certFile := resolvePath(Dir, fileCfg.CertFile)
+	keyFile := resolvePath(Dir, fileCfg.KeyFile)
+	if fileCfg.CertFile == "" {
+		certDir, err := os.UserCacheDir()
+		if err != nil {
+			log.Fatal(err)
+		}
+		certDir = filepath.Join(certDir, "hotweb")
+		certFile, keyFile, err = devcert.Ensure(certDir, devcert.DefaultHosts())
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("using certificate signed by %s, trust it to avoid browser warnings\n",
+			filepath.Join(certDir, devcert.CAFilename))
+	}
+
+	url := "https://localhost:" + fileCfg.Port
 	open.Start(url)
 
 	log.Printf("serving at %s\n", url)
-	http.ListenAndServe(listenAddr, handlers.LoggingHandler(os.Stdout, hw))
+	log.Fatal(http.ListenAndServeTLS(listenAddr, certFile, keyFile, handler))
 }
@@ -3,11 +3,14 @@ module github.com/progrium/hotweb
 go 1.13
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/gorilla/handlers v1.4.2
 	github.com/gorilla/websocket v1.4.1
 	github.com/progrium/esbuild v0.0.0-20200327212623-fae14fb26173
 	github.com/progrium/watcher v1.0.8-0.20200403214642-88c0f931de38
 	github.com/skratchdot/open-golang v0.0.0-20200116055534-eef842397966
 	github.com/spf13/afero v1.2.2
+	github.com/yuin/goldmark v1.2.1
 	golang.org/x/text v0.3.2 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
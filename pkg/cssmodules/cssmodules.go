@@ -0,0 +1,207 @@
+package cssmodules
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ScopedName returns the name a class or id is rewritten to in scope. It
+// only depends on the name and scope so it is stable across reloads.
+func ScopedName(name, scope string) string {
+	sum := sha1.Sum([]byte(scope))
+	return name + "_" + hex.EncodeToString(sum[:])[:8]
+}
+
+// Scope rewrites class and id selectors in a stylesheet to names scoped
+// with ScopedName, returning the rewritten stylesheet and a mapping from
+// the original names to the scoped names. Declarations, at-rule preludes
+// and keyframe selectors are left alone.
+func Scope(src []byte, scope string) ([]byte, map[string]string) {
+	s := &scoper{
+		src:   string(src),
+		scope: scope,
+		names: make(map[string]string),
+	}
+	s.scopeBlock(false)
+	return []byte(s.out.String()), s.names
+}
+
+type scoper struct {
+	src   string
+	pos   int
+	scope string
+	names map[string]string
+	out   strings.Builder
+}
+
+// scopeBlock processes rules until the end of the enclosing block. When
+// declarations is true the block contains declarations, not rules.
+func (s *scoper) scopeBlock(declarations bool) {
+	for s.pos < len(s.src) {
+		start := s.pos
+		if declarations {
+			stop := s.scan("{}")
+			s.out.WriteString(s.src[start:s.pos])
+			s.copyNext()
+			if stop != '{' {
+				return
+			}
+			// nested block inside declarations
+			s.scopeBlock(true)
+			continue
+		}
+		stop := s.scan("{};")
+		prelude := s.src[start:s.pos]
+		if stop == '{' && !strings.HasPrefix(strings.TrimSpace(stripComments(prelude)), "@") {
+			s.out.WriteString(s.scopeSelector(prelude))
+		} else {
+			s.out.WriteString(prelude)
+		}
+		s.copyNext()
+		switch stop {
+		case '}':
+			return
+		case '{':
+			s.scopeBlock(!isGroupingRule(strings.TrimSpace(stripComments(prelude))))
+		}
+	}
+}
+
+// scan advances to the next of the stop characters outside of strings
+// and comments, returning the character found.
+func (s *scoper) scan(stop string) rune {
+	for s.pos < len(s.src) {
+		r, w := utf8.DecodeRuneInString(s.src[s.pos:])
+		switch {
+		case strings.ContainsRune(stop, r):
+			return r
+		case r == '"' || r == '\'':
+			s.pos += w
+			s.scanString(r)
+		case strings.HasPrefix(s.src[s.pos:], "/*"):
+			end := strings.Index(s.src[s.pos+2:], "*/")
+			if end < 0 {
+				s.pos = len(s.src)
+			} else {
+				s.pos += end + 4
+			}
+		default:
+			s.pos += w
+		}
+	}
+	return -1
+}
+
+func (s *scoper) scanString(quote rune) {
+	for s.pos < len(s.src) {
+		r, w := utf8.DecodeRuneInString(s.src[s.pos:])
+		s.pos += w
+		if r == '\\' && s.pos < len(s.src) {
+			_, w = utf8.DecodeRuneInString(s.src[s.pos:])
+			s.pos += w
+			continue
+		}
+		if r == quote || r == '\n' {
+			return
+		}
+	}
+}
+
+func (s *scoper) copyNext() {
+	if s.pos >= len(s.src) {
+		return
+	}
+	_, w := utf8.DecodeRuneInString(s.src[s.pos:])
+	s.out.WriteString(s.src[s.pos : s.pos+w])
+	s.pos += w
+}
+
+// scopeSelector rewrites the class and id names in a selector list.
+func (s *scoper) scopeSelector(sel string) string {
+	var out strings.Builder
+	for i := 0; i < len(sel); {
+		c := sel[i]
+		switch {
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(sel[i+1:], c)
+			if end < 0 {
+				end = len(sel) - i - 2
+			}
+			out.WriteString(sel[i : i+end+2])
+			i += end + 2
+		case c == '[':
+			end := strings.IndexByte(sel[i:], ']')
+			if end < 0 {
+				end = len(sel) - i - 1
+			}
+			out.WriteString(sel[i : i+end+1])
+			i += end + 1
+		case strings.HasPrefix(sel[i:], "/*"):
+			end := strings.Index(sel[i+2:], "*/")
+			if end < 0 {
+				end = len(sel) - i - 4
+			}
+			out.WriteString(sel[i : i+end+4])
+			i += end + 4
+		case c == '.' || c == '#':
+			name := identAt(sel[i+1:])
+			if name == "" {
+				out.WriteByte(c)
+				i++
+				continue
+			}
+			scoped, ok := s.names[name]
+			if !ok {
+				scoped = ScopedName(name, s.scope)
+				s.names[name] = scoped
+			}
+			out.WriteByte(c)
+			out.WriteString(scoped)
+			i += 1 + len(name)
+		default:
+			out.WriteByte(c)
+			i++
+		}
+	}
+	return out.String()
+}
+
+// identAt returns the CSS identifier at the start of s.
+func identAt(s string) string {
+	for i, r := range s {
+		if r == '-' || r == '_' || unicode.IsLetter(r) || r >= utf8.RuneSelf ||
+			(i > 0 && unicode.IsDigit(r)) {
+			continue
+		}
+		return s[:i]
+	}
+	return s
+}
+
+// isGroupingRule reports whether an at-rule contains rules rather than
+// declarations or keyframes.
+func isGroupingRule(prelude string) bool {
+	for _, rule := range []string{"@media", "@supports", "@layer", "@container", "@document", "@scope"} {
+		if strings.HasPrefix(prelude, rule) {
+			return true
+		}
+	}
+	return false
+}
+
+func stripComments(s string) string {
+	for {
+		start := strings.Index(s, "/*")
+		if start < 0 {
+			return s
+		}
+		end := strings.Index(s[start+2:], "*/")
+		if end < 0 {
+			return s[:start]
+		}
+		s = s[:start] + s[start+2+end+2:]
+	}
+}
@@ -0,0 +1,49 @@
+package cssmodules
+
+import (
+	"fmt"
+	"testing"
+)
+
+const TestPath = "/lib/card.module.css"
+
+func TestScope(t *testing.T) {
+	title := ScopedName("title", TestPath)
+	main := ScopedName("main", TestPath)
+	var tests = []struct {
+		in  string
+		out string
+	}{
+		{
+			".title { color: #fff; }",
+			fmt.Sprintf(".%s { color: #fff; }", title),
+		},
+		{
+			"#main > a.title:hover, [data-x=\".y\"] { margin: .5em; }",
+			fmt.Sprintf("#%s > a.%s:hover, [data-x=\".y\"] { margin: .5em; }", main, title),
+		},
+		{
+			"@media (min-width: 10.5em) { .title { background: url(a.b.png); } }",
+			fmt.Sprintf("@media (min-width: 10.5em) { .%s { background: url(a.b.png); } }", title),
+		},
+		{
+			"@keyframes fade { from { opacity: 0; } 50.5% { opacity: .5; } }\n/* .title */",
+			"@keyframes fade { from { opacity: 0; } 50.5% { opacity: .5; } }\n/* .title */",
+		},
+	}
+	for idx, tt := range tests {
+		t.Run(fmt.Sprintf("test%d", idx), func(t *testing.T) {
+			got, _ := Scope([]byte(tt.in), TestPath)
+			if string(got) != tt.out {
+				t.Errorf("got %q, want %q", got, tt.out)
+			}
+		})
+	}
+
+	t.Run("names", func(t *testing.T) {
+		_, names := Scope([]byte(".title {}\n#main {}"), TestPath)
+		if names["title"] != title || names["main"] != main || len(names) != 2 {
+			t.Errorf("got %v", names)
+		}
+	})
+}
@@ -0,0 +1,174 @@
+package devcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	CAFilename    = "ca.pem"
+	CAKeyFilename = "ca-key.pem"
+	CertFilename  = "cert.pem"
+	KeyFilename   = "key.pem"
+	CAValidFor    = 10 * 365 * 24 * time.Hour
+	LeafValidFor  = 365 * 24 * time.Hour
+)
+
+// DefaultHosts are the names a development certificate is valid for.
+func DefaultHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" {
+		hosts = append(hosts, hostname)
+	}
+	return hosts
+}
+
+// Ensure returns the paths of a certificate and key for hosts in dir,
+// generating a local CA and signing a new leaf certificate with it if
+// there is no valid one cached. The CA is reused across calls so it only
+// needs to be trusted once, see CAFilename.
+func Ensure(dir string, hosts []string) (certFile, keyFile string, err error) {
+	if len(hosts) == 0 {
+		hosts = DefaultHosts()
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", "", err
+	}
+	certFile = filepath.Join(dir, CertFilename)
+	keyFile = filepath.Join(dir, KeyFilename)
+	ca, caKey, err := ensureCA(dir)
+	if err != nil {
+		return "", "", err
+	}
+	if leafValid(certFile, keyFile, hosts, ca) {
+		return certFile, keyFile, nil
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	tmpl, err := newTemplate(hosts[0], LeafValidFor)
+	if err != nil {
+		return "", "", err
+	}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, host := range hosts {
+		if ip := net.ParseIP(host); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, host)
+		}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+	if err != nil {
+		return "", "", err
+	}
+	if err := writePair(certFile, keyFile, der, key); err != nil {
+		return "", "", err
+	}
+	return certFile, keyFile, nil
+}
+
+func ensureCA(dir string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	caFile := filepath.Join(dir, CAFilename)
+	caKeyFile := filepath.Join(dir, CAKeyFilename)
+	if pair, err := tls.LoadX509KeyPair(caFile, caKeyFile); err == nil {
+		ca, err := x509.ParseCertificate(pair.Certificate[0])
+		if err == nil && time.Now().Before(ca.NotAfter) {
+			if key, ok := pair.PrivateKey.(*ecdsa.PrivateKey); ok {
+				return ca, key, nil
+			}
+		}
+	}
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl, err := newTemplate("hotweb local CA", CAValidFor)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := writePair(caFile, caKeyFile, der, key); err != nil {
+		return nil, nil, err
+	}
+	ca, err := x509.ParseCertificate(der)
+	return ca, key, err
+}
+
+// leafValid reports whether the cached leaf certificate is signed by ca
+// and valid for hosts.
+func leafValid(certFile, keyFile string, hosts []string, ca *x509.Certificate) bool {
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return false
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		return false
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	for _, host := range hosts {
+		_, err := cert.Verify(x509.VerifyOptions{
+			DNSName:   host,
+			Roots:     roots,
+			KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		})
+		if err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func newTemplate(commonName string, validFor time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	return &x509.Certificate{
+		SerialNumber: serial,
+		Subject: pkix.Name{
+			Organization: []string{"hotweb development"},
+			CommonName:   commonName,
+		},
+		NotBefore: time.Now().Add(-time.Hour),
+		NotAfter:  time.Now().Add(validFor),
+	}, nil
+}
+
+func writePair(certFile, keyFile string, der []byte, key *ecdsa.PrivateKey) error {
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return err
+	}
+	certPem := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPem := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	if err := ioutil.WriteFile(certFile, certPem, 0644); err != nil {
+		return fmt.Errorf("devcert: %v", err)
+	}
+	if err := ioutil.WriteFile(keyFile, keyPem, 0600); err != nil {
+		return fmt.Errorf("devcert: %v", err)
+	}
+	return nil
+}
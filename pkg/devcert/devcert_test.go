@@ -0,0 +1,89 @@
+package devcert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestEnsure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "devcert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	certFile, keyFile, err := Ensure(dir, []string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(pair.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	caPem, err := ioutil.ReadFile(dir + "/" + CAFilename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AppendCertsFromPEM(caPem)
+	if _, err := cert.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: roots}); err != nil {
+		t.Errorf("leaf not signed by CA: %v", err)
+	}
+
+	t.Run("cached", func(t *testing.T) {
+		before, _ := ioutil.ReadFile(certFile)
+		if _, _, err := Ensure(dir, []string{"localhost"}); err != nil {
+			t.Fatal(err)
+		}
+		after, _ := ioutil.ReadFile(certFile)
+		if string(before) != string(after) {
+			t.Error("certificate was regenerated")
+		}
+	})
+
+	t.Run("new host", func(t *testing.T) {
+		before, _ := ioutil.ReadFile(dir + "/" + CAFilename)
+		if _, _, err := Ensure(dir, []string{"example.test"}); err != nil {
+			t.Fatal(err)
+		}
+		after, _ := ioutil.ReadFile(dir + "/" + CAFilename)
+		if string(before) != string(after) {
+			t.Error("CA was regenerated")
+		}
+	})
+
+	t.Run("new CA", func(t *testing.T) {
+		if err := os.Remove(dir + "/" + CAFilename); err != nil {
+			t.Fatal(err)
+		}
+		certFile, keyFile, err := Ensure(dir, []string{"localhost"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		caPem, err := ioutil.ReadFile(dir + "/" + CAFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		roots := x509.NewCertPool()
+		roots.AppendCertsFromPEM(caPem)
+		if _, err := cert.Verify(x509.VerifyOptions{DNSName: "localhost", Roots: roots}); err != nil {
+			t.Errorf("leaf not reissued by new CA: %v", err)
+		}
+	})
+}
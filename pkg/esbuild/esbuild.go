@@ -18,7 +18,9 @@ import (
 
 var JsxFactory = "m"
 
-func jsxFactory() string {
+// Factory returns the JSX factory, which can be overridden with the
+// JSX_FACTORY environment variable.
+func Factory() string {
 	if os.Getenv("JSX_FACTORY") != "" {
 		return os.Getenv("JSX_FACTORY")
 	}
@@ -29,7 +31,7 @@ func BuildFile(fs afero.Fs, filepath string) ([]byte, error) {
 	parseOptions := parser.ParseOptions{
 		Defines: make(map[string]ast.E),
 		JSX: parser.JSXOptions{
-			Factory: []string{jsxFactory()},
+			Factory: []string{Factory()},
 		},
 	}
 	bundleOptions := bundler.BundleOptions{}
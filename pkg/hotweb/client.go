@@ -0,0 +1,104 @@
+package hotweb
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+)
+
+// client is a connected websocket or event stream client and the paths
+// it subscribed to.
+type client struct {
+	id      string
+	updates chan updateMsg
+	done    chan struct{}
+	closed  sync.Once
+
+	mu       sync.Mutex
+	origin   string // for pushed source
+	paths    map[string]bool
+	prefixes []string
+}
+
+func newClient() *client {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &client{
+		id:      hex.EncodeToString(id),
+		updates: make(chan updateMsg),
+		done:    make(chan struct{}),
+	}
+}
+
+// send sends an update to the client unless it disconnected.
+func (c *client) send(update updateMsg) {
+	select {
+	case c.updates <- update:
+	case <-c.done:
+	}
+}
+
+// close is called when the client disconnects.
+func (c *client) close() {
+	c.closed.Do(func() {
+		close(c.done)
+	})
+}
+
+// subscribe adds paths the client wants changes to. Paths ending with
+// * match every path starting with the rest of it. The origin the client
+// imports modules from is used in pushed source.
+func (c *client) subscribe(paths []string, origin string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if origin != "" {
+		c.origin = origin
+	}
+	if c.paths == nil {
+		c.paths = make(map[string]bool)
+	}
+	for _, p := range paths {
+		if strings.HasSuffix(p, "*") {
+			c.prefixes = append(c.prefixes, strings.TrimSuffix(p, "*"))
+		} else {
+			c.paths[p] = true
+		}
+	}
+}
+
+func (c *client) subscribed(urlPath string) bool {
+	if c.paths[urlPath] {
+		return true
+	}
+	for _, prefix := range c.prefixes {
+		if strings.HasPrefix(urlPath, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// filter returns the changes in an update the client subscribed to, and
+// whether there are any. Clients that haven't subscribed get every change.
+func (c *client) filter(update updateMsg) (updateMsg, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	filtered := updateMsg{ID: update.ID}
+	for _, change := range update.Changes {
+		match := c.paths == nil || c.subscribed(change.Path)
+		for _, style := range change.Styles {
+			match = match || c.subscribed(style)
+		}
+		if !match {
+			continue
+		}
+		if c.origin == "" {
+			change.Source = ""
+		} else {
+			change.Source = strings.Replace(change.Source, originPlaceholder, c.origin, -1)
+		}
+		filtered.Changes = append(filtered.Changes, change)
+	}
+	return filtered, len(filtered.Changes) > 0
+}
@@ -0,0 +1,185 @@
+package hotweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/afero"
+)
+
+var (
+	esmExpr          = regexp.MustCompile(`\b(import\b\s*[\w{*'"]|export\b\s*[\w{*])`)
+	cjsExpr          = regexp.MustCompile(`\b(module\.exports|exports\.[\w$]+\s*=|require\s*\()`)
+	cjsRequireExpr   = regexp.MustCompile(`\brequire\s*\(\s*["']([^"']+)["']\s*\)`)
+	cjsNamedExpr     = regexp.MustCompile(`\b(?:module\.)?exports\.([\w$]+)\s*=[^=]`)
+	cjsObjectExpr    = regexp.MustCompile(`\bmodule\.exports\s*=\s*\{`)
+	cjsObjectKeyExpr = regexp.MustCompile(`^\s*(?:async\s+)?\*?\s*([A-Za-z_$][\w$]*)\s*(?:[:,(}]|$)`)
+)
+
+// isCommonJS reports whether a module is CommonJS, either by extension
+// or by using module.exports, exports or require without ES module syntax.
+func isCommonJS(fsPath string, src []byte) bool {
+	if path.Ext(fsPath) == ".cjs" {
+		return true
+	}
+	if path.Ext(fsPath) != ".js" {
+		return false
+	}
+	return cjsExpr.Match(src) && !hasModuleSyntax(src)
+}
+
+// hasModuleSyntax reports whether src has import or export statements
+// outside comments and literals, wherever they are on a line so minified
+// modules are recognized too.
+func hasModuleSyntax(src []byte) bool {
+	spans := literalSpans(src)
+	for _, loc := range esmExpr.FindAllIndex(src, -1) {
+		if inSpans(spans, loc[0]) {
+			continue
+		}
+		if loc[0] > 0 && (src[loc[0]-1] == '.' || src[loc[0]-1] == '$') {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// cjsExports infers the named exports of a CommonJS module from assignments
+// to exports properties and keys of an object literal assigned to
+// module.exports.
+func cjsExports(src []byte) []string {
+	set := make(map[string]bool)
+	for _, match := range cjsNamedExpr.FindAllSubmatch(src, -1) {
+		set[string(match[1])] = true
+	}
+	if loc := cjsObjectExpr.FindIndex(src); loc != nil {
+		for _, entry := range topLevelEntries(string(src[loc[1]:])) {
+			if match := cjsObjectKeyExpr.FindStringSubmatch(entry); match != nil {
+				set[match[1]] = true
+			}
+		}
+	}
+	var exports []string
+	for name := range set {
+		if name != "__esModule" && isExportableName(name) && !strings.HasPrefix(name, "__hotweb_") {
+			exports = append(exports, name)
+		}
+	}
+	sort.Strings(exports)
+	return exports
+}
+
+// topLevelEntries splits the body of an object literal, starting after
+// its opening brace, into its comma separated entries.
+func topLevelEntries(body string) []string {
+	var entries []string
+	depth := 0
+	start := 0
+	var quote rune
+	for i, r := range body {
+		switch {
+		case quote != 0:
+			if r == quote && body[i-1] != '\\' {
+				quote = 0
+			}
+		case r == '"' || r == '\'' || r == '`':
+			quote = r
+		case r == '{' || r == '(' || r == '[':
+			depth++
+		case r == ')' || r == ']':
+			depth--
+		case r == '}':
+			if depth == 0 {
+				return append(entries, body[start:i])
+			}
+			depth--
+		case r == ',' && depth == 0:
+			entries = append(entries, body[start:i]+",")
+			start = i + 1
+		}
+	}
+	return entries
+}
+
+type cjsRequire struct {
+	Name     string
+	Spec     string // quoted specifier passed to require
+	Path     string // http path of the required module
+	CommonJS bool
+}
+
+// wrapCommonJS makes an ES module from a CommonJS module at urlPath with
+// module.exports as the default export and inferred named exports.
+// Local files passed to require are imported.
+func (m *Handler) wrapCommonJS(urlPath string, src []byte) ([]byte, error) {
+	tmpl := template.Must(template.New("commonjs").Parse(CommonJSModuleTmpl))
+
+	var requires []cjsRequire
+	seen := make(map[string]bool)
+	for _, match := range cjsRequireExpr.FindAllSubmatch(src, -1) {
+		spec := string(match[1])
+		if seen[spec] || !isLocalURL(spec) || !(strings.HasPrefix(spec, ".") || strings.HasPrefix(spec, "/")) {
+			continue
+		}
+		seen[spec] = true
+		depPath, depSrc, ok := m.resolveRequire(urlPath, spec)
+		if !ok {
+			continue
+		}
+		quoted, _ := json.Marshal(spec)
+		depFsPath, _ := m.fsPath(depPath)
+		requires = append(requires, cjsRequire{
+			Name:     fmt.Sprintf("__hotweb_require%d", len(requires)),
+			Spec:     string(quoted),
+			Path:     depPath,
+			CommonJS: isCommonJS(depFsPath, depSrc),
+		})
+	}
+
+	var b strings.Builder
+	err := tmpl.Execute(&b, map[string]interface{}{
+		"Requires": requires,
+		"Exports":  cjsExports(src),
+		"Source":   string(src),
+	})
+	return []byte(b.String()), err
+}
+
+// resolveRequire finds the module a require specifier refers to the way
+// Node would for local files.
+func (m *Handler) resolveRequire(fromPath, spec string) (string, []byte, bool) {
+	base := resolveURL(fromPath, spec)
+	for _, candidate := range []string{base, base + ".js", base + ".cjs", base + "/index.js"} {
+		fsPath, ok := m.fsPath(candidate)
+		if !ok {
+			continue
+		}
+		if fi, err := m.Fs.Stat(fsPath); err != nil || fi.IsDir() {
+			continue
+		}
+		src, err := afero.ReadFile(m.Fs, fsPath)
+		if err == nil {
+			return candidate, src, true
+		}
+	}
+	return "", nil, false
+}
+
+// readModule reads the source of a module, wrapping CommonJS modules so
+// they can be imported as ES modules.
+func (m *Handler) readModule(fsPath string) ([]byte, error) {
+	src, err := afero.ReadFile(m.Fs, fsPath)
+	if err != nil {
+		return nil, err
+	}
+	if isCommonJS(fsPath, src) {
+		return m.wrapCommonJS(m.urlPath(fsPath), src)
+	}
+	return src, nil
+}
@@ -0,0 +1,183 @@
+package hotweb
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+
+	"github.com/spf13/afero"
+)
+
+var cssImportExpr = regexp.MustCompile(`@import\s+(?:url\(\s*)?["']?([^"'()\s;]+)["']?\s*\)?`)
+
+// cssImports returns the specifiers of @import rules in a stylesheet.
+func cssImports(src []byte) []string {
+	var imports []string
+	for _, match := range cssImportExpr.FindAllSubmatch(src, -1) {
+		imports = append(imports, string(match[1]))
+	}
+	return imports
+}
+
+// isLocalURL reports whether a specifier refers to a path on this server.
+func isLocalURL(spec string) bool {
+	return !strings.Contains(spec, "://") && !strings.HasPrefix(spec, "//") && !strings.HasPrefix(spec, "data:")
+}
+
+// resolveURL resolves a specifier relative to the http path of the
+// file it appears in.
+func resolveURL(fromPath, spec string) string {
+	spec = strings.SplitN(spec, "?", 2)[0]
+	if strings.HasPrefix(spec, "/") {
+		return path.Clean(spec)
+	}
+	return path.Join(path.Dir(fromPath), spec)
+}
+
+// importGraph records the local @import rules of stylesheets as they're
+// served and changed, so the stylesheets importing a changed one can be
+// found without reading every stylesheet.
+type importGraph struct {
+	mu      sync.Mutex
+	imports map[string][]string // http path to the http paths it imports
+}
+
+func (g *importGraph) set(urlPath string, imports []string) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.imports == nil {
+		g.imports = make(map[string][]string)
+	}
+	g.imports[urlPath] = imports
+}
+
+// importers returns urlPath and the http paths of every stylesheet that
+// imports it, directly or through other imports.
+func (g *importGraph) importers(urlPath string) []string {
+	g.mu.Lock()
+	importedBy := make(map[string][]string)
+	for from, imports := range g.imports {
+		for _, dep := range imports {
+			importedBy[dep] = append(importedBy[dep], from)
+		}
+	}
+	g.mu.Unlock()
+	seen := map[string]bool{urlPath: true}
+	queue := []string{urlPath}
+	for len(queue) > 0 {
+		p := queue[0]
+		queue = queue[1:]
+		for _, parent := range importedBy[p] {
+			if !seen[parent] {
+				seen[parent] = true
+				queue = append(queue, parent)
+			}
+		}
+	}
+	var paths []string
+	for p := range seen {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// trackStylesheet records the local imports of the stylesheet at urlPath.
+func (m *Handler) trackStylesheet(urlPath string, src []byte) {
+	var imports []string
+	for _, spec := range cssImports(src) {
+		if isLocalURL(spec) {
+			imports = append(imports, resolveURL(urlPath, spec))
+		}
+	}
+	m.styles.set(urlPath, imports)
+}
+
+// trackStylesheetFile records the local imports of the stylesheet at
+// fsPath, if it can be read.
+func (m *Handler) trackStylesheetFile(fsPath string) {
+	if src, err := afero.ReadFile(m.Fs, fsPath); err == nil {
+		m.trackStylesheet(m.urlPath(fsPath), src)
+	}
+}
+
+// cssDependents returns the http paths of the stylesheet at urlPath and
+// every served stylesheet that imports it, directly or through other
+// imports.
+func (m *Handler) cssDependents(urlPath string) []string {
+	return m.styles.importers(urlPath)
+}
+
+// handleStylesheet serves a versioned stylesheet with the version added
+// to its local @import rules so imported sheets are not stale cache hits.
+func (m *Handler) handleStylesheet(w http.ResponseWriter, r *http.Request) {
+	fsPath, _ := m.fsPath(r.URL.Path)
+	src, err := afero.ReadFile(m.Fs, fsPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	m.trackStylesheet(r.URL.Path, src)
+	src = cssImportExpr.ReplaceAllFunc(src, func(rule []byte) []byte {
+		spec := cssImportExpr.FindSubmatch(rule)[1]
+		if !isLocalURL(string(spec)) {
+			return rule
+		}
+		versioned := strings.SplitN(string(spec), "?", 2)[0] + "?" + r.URL.RawQuery
+		return []byte(strings.Replace(string(rule), string(spec), versioned, 1))
+	})
+	w.Header().Set("content-type", "text/css; charset=utf-8")
+	w.Write(src)
+}
+
+var cssURLExpr = regexp.MustCompile(`(url\(\s*["']?|@import\s+["'])([^"'()\s]+)`)
+
+// absoluteCSSURLs makes relative urls in a stylesheet absolute so it can
+// be applied outside of the location it was loaded from.
+func absoluteCSSURLs(src []byte, fromPath string) []byte {
+	return cssURLExpr.ReplaceAllFunc(src, func(ref []byte) []byte {
+		match := cssURLExpr.FindSubmatch(ref)
+		spec := string(match[2])
+		if !isLocalURL(spec) || strings.HasPrefix(spec, "/") || strings.HasPrefix(spec, "#") {
+			return ref
+		}
+		parts := strings.SplitN(spec, "?", 2)
+		abs := resolveURL(fromPath, parts[0])
+		if len(parts) > 1 {
+			abs = withQuery(abs, parts[1])
+		}
+		return append(append([]byte{}, match[1]...), abs...)
+	})
+}
+
+// handleStyleModule serves a stylesheet wrapped in a JavaScript module
+// generated from tmplSrc so it can be imported by other modules. The
+// module is re-imported with query to hot swap the styles.
+func (m *Handler) handleStyleModule(w http.ResponseWriter, r *http.Request, tmplSrc, query string) {
+	tmpl := template.Must(template.New("style").Parse(tmplSrc))
+
+	fsPath, _ := m.fsPath(r.URL.Path)
+	src, err := afero.ReadFile(m.Fs, fsPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		debug(err)
+		return
+	}
+	m.trackStylesheet(r.URL.Path, src)
+	urlPath, _ := json.Marshal(r.URL.Path)
+	reimport, _ := json.Marshal(r.URL.Path + "?" + query)
+	css, _ := json.Marshal(string(absoluteCSSURLs(src, r.URL.Path)))
+
+	w.Header().Set("content-type", "text/javascript")
+	tmpl.Execute(w, map[string]interface{}{
+		"Path":       string(urlPath),
+		"CSS":        string(css),
+		"Reimport":   string(reimport),
+		"ClientPath": path.Join(m.Prefix, InternalPath, ClientFilename),
+	})
+}
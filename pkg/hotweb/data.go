@@ -0,0 +1,81 @@
+package hotweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// DataExts are the extensions of data files importable as modules.
+var DataExts = []string{".json", ".yaml", ".yml", ".toml"}
+
+// decodeData decodes a JSON, YAML or TOML file by extension.
+func decodeData(ext string, src []byte) (interface{}, error) {
+	var data interface{}
+	var err error
+	switch ext {
+	case ".json":
+		err = json.Unmarshal(src, &data)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(src, &data)
+	case ".toml":
+		var table map[string]interface{}
+		err = toml.Unmarshal(src, &table)
+		data = table
+	default:
+		err = fmt.Errorf("unsupported data file %s", ext)
+	}
+	return data, err
+}
+
+// buildDataModule makes a module default exporting the data in a data
+// file with its top-level keys as named exports. Decoding errors are
+// thrown by the module so they show up in the browser.
+func buildDataModule(filename string, src []byte) ([]byte, error) {
+	tmpl := template.Must(template.New("data").Parse(DataModuleTmpl))
+
+	var exports []string
+	jsonData, err := func() ([]byte, error) {
+		data, err := decodeData(path.Ext(filename), src)
+		if err != nil {
+			return nil, err
+		}
+		if obj, ok := data.(map[string]interface{}); ok {
+			for key := range obj {
+				if isExportableName(key) {
+					exports = append(exports, key)
+				}
+			}
+			sort.Strings(exports)
+		}
+		return json.MarshalIndent(data, "", "\t")
+	}()
+	if err != nil {
+		msg, _ := json.Marshal(fmt.Sprintf("hotweb: %s: %v", path.Base(filename), err))
+		return []byte(fmt.Sprintf("throw new Error(%s);\n", msg)), nil
+	}
+
+	var b strings.Builder
+	err = tmpl.Execute(&b, map[string]interface{}{
+		"Data":    string(jsonData),
+		"Exports": exports,
+	})
+	return []byte(b.String()), err
+}
+
+// rewriteDataImports points imports of data files at generated modules.
+func rewriteDataImports(imp moduleImport) (string, bool) {
+	if !isLocalURL(imp.Spec) || strings.Contains(imp.Spec, "?") {
+		return "", false
+	}
+	if !contains(DataExts, path.Ext(imp.Spec)) {
+		return "", false
+	}
+	return imp.Spec + ".js", true
+}
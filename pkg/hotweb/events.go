@@ -0,0 +1,87 @@
+package hotweb
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// EventsFilename is the path under InternalPath of the server-sent
+// events endpoint clients fall back to if websockets don't work.
+var EventsFilename = "events"
+
+// EventsKeepalive is how often a comment is sent on idle event streams
+// so proxies don't close them.
+var EventsKeepalive = 15 * time.Second
+
+// handleEvents streams updates to a client as server-sent events. The
+// first event has the id the client posts its messages back with.
+func (m *Handler) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "POST" {
+		m.handleEventsPost(w, r)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	c := newClient()
+	m.clients.Store(c, struct{}{})
+	defer func() {
+		m.clients.Delete(c)
+		c.close()
+	}()
+	debug("new event stream connection")
+
+	w.Header().Set("content-type", "text/event-stream")
+	w.Header().Set("cache-control", "no-cache")
+	hello, _ := json.Marshal(map[string]string{"id": c.id})
+	fmt.Fprintf(w, "event: hello\ndata: %s\n\n", hello)
+	flusher.Flush()
+
+	keepalive := time.NewTicker(EventsKeepalive)
+	defer keepalive.Stop()
+	for {
+		select {
+		case msg := <-c.updates:
+			b, err := json.Marshal(msg)
+			if err != nil {
+				debug(err)
+				continue
+			}
+			fmt.Fprintf(w, "data: %s\n\n", b)
+		case <-keepalive.C:
+			fmt.Fprint(w, ": keepalive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// handleEventsPost handles a message from the event stream client with
+// the id in the query.
+func (m *Handler) handleEventsPost(w http.ResponseWriter, r *http.Request) {
+	var c *client
+	id := r.URL.Query().Get("id")
+	m.clients.Range(func(k, v interface{}) bool {
+		if k.(*client).id == id {
+			c = k.(*client)
+			return false
+		}
+		return true
+	})
+	if c == nil {
+		http.NotFound(w, r)
+		return
+	}
+	var msg clientMsg
+	if err := json.NewDecoder(r.Body).Decode(&msg); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	m.handleClientMsg(c, msg)
+	w.WriteHeader(http.StatusNoContent)
+}
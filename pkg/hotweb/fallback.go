@@ -0,0 +1,61 @@
+package hotweb
+
+import (
+	"bytes"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// matchFallback reports whether a request is for a client-side route
+// that should be served the Fallback document.
+func (m *Handler) matchFallback(r *http.Request) bool {
+	if m.Fallback == "" || (r.Method != "GET" && r.Method != "HEAD") {
+		return false
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "text/html") {
+		return false
+	}
+	if strings.HasPrefix(r.URL.Path, path.Join(m.Prefix, InternalPath)) {
+		return false
+	}
+	if !contains([]string{"", ".html", ".htm"}, path.Ext(r.URL.Path)) {
+		return false
+	}
+	if _, ok := m.mountFor(r.URL.Path); !ok {
+		return false
+	}
+	return !m.matchFile(r)
+}
+
+func (m *Handler) handleFallback(w http.ResponseWriter, r *http.Request) {
+	fsPath, ok := m.fsPath(m.Fallback)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if m.LazyWatch {
+		defer m.watchFile(fsPath)
+	}
+	if m.Templates {
+		m.servePage(w, r, fsPath)
+		return
+	}
+	fi, err := m.Fs.Stat(fsPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		debug(err)
+		return
+	}
+	page, err := afero.ReadFile(m.Fs, fsPath)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		debug(err)
+		return
+	}
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	m.addModulePreloads(w, r.URL.Path, page)
+	http.ServeContent(w, r, path.Base(fsPath), fi.ModTime(), bytes.NewReader(page))
+}
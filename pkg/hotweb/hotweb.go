@@ -1,11 +1,17 @@
 package hotweb
 
 import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"os"
 	"path"
+	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"text/template"
@@ -21,6 +27,11 @@ import (
 
 const (
 	DefaultWatchInterval = time.Millisecond * 100
+
+	// transformVersion is part of the disk cache key and should change
+	// whenever the output of the transforms does, so files made by an
+	// older hotweb aren't served from the cache.
+	transformVersion = "1"
 )
 
 var (
@@ -38,24 +49,48 @@ type Handler struct {
 	Fs            *makefs.Fs
 	ServeRoot     string
 	Prefix        string
+	Mounts        []Mount
+	Fallback      string
+	Templates     bool
+	ReloadOnError bool
+	PushSource    bool
+	Warmup        int
+	LazyWatch     bool
 	IgnoreDirs    []string
 	WatchInterval time.Duration
 
 	Upgrader websocket.Upgrader
 	Watcher  *watcher.Watcher
 
-	fileserver http.Handler
-	clients    sync.Map
-	mux        http.Handler
-	muxOnce    sync.Once
+	// OnAck is called when a client acknowledges an update.
+	OnAck func(Ack)
+
+	upstreams []upstream
+	clients   sync.Map
+	updates   int64 // last update id, only used by Watch
+	watched   sync.Map
+	styles    importGraph
+	mux       http.Handler
+	muxOnce   sync.Once
+}
+
+// Mount serves an additional filesystem directory at a http path.
+type Mount struct {
+	Dir  string // abs path in filesystem to serve
+	Path string // http path to serve it at
 }
 
-func newWriteWatcher(fs afero.Fs, root string) (*watcher.Watcher, error) {
+func newWriteWatcher(fs afero.Fs, roots ...string) (*watcher.Watcher, error) {
 	w := watcher.New()
 	w.SetFileSystem(fs)
 	w.SetMaxEvents(1)
 	w.FilterOps(watcher.Write)
-	return w, w.AddRecursive(root)
+	for _, root := range roots {
+		if err := w.AddRecursive(root); err != nil {
+			return w, err
+		}
+	}
+	return w, nil
 }
 
 type Config struct {
@@ -67,6 +102,16 @@ type Config struct {
 	ReloadExport  string
 	WatchInterval time.Duration
 	IgnoreDirs    []string
+	Mounts        []Mount // optional directories served outside ServeRoot
+	Proxies       []Proxy // optional upstreams for requests not served by hotweb
+	Fallback      string  // optional http path of document served for client-side routes
+	Templates     bool    // render served .html files as templates with includes
+	ReloadOnError bool    // reload the page when a module fails to hot reload
+	PushSource    bool    // send the source of changed modules with changes
+	Warmup        int     // optional number of workers building made files when watching starts, unless LazyWatch
+	CacheDir      string  // optional directory made files are cached in across restarts
+	CacheSize     int64   // optional max bytes of the cache directory
+	LazyWatch     bool    // only watch files once they're served
 }
 
 func New(cfg Config) *Handler {
@@ -97,24 +142,62 @@ func New(cfg Config) *Handler {
 	cache := afero.NewMemMapFs()
 	mfs := makefs.New(fs, cache)
 
+	prefix = path.Join("/", prefix)
+	mounts := []Mount{{Dir: serveRoot, Path: prefix}}
+	for _, mount := range cfg.Mounts {
+		mounts = append(mounts, Mount{
+			Dir:  mount.Dir,
+			Path: path.Join("/", mount.Path),
+		})
+	}
+
+	if cfg.CacheDir != "" {
+		dc, err := makefs.NewDiskCache(cfg.CacheDir, cfg.CacheSize)
+		if err != nil {
+			panic(err)
+		}
+		// made files depend on these as well as their sources
+		options := fmt.Sprint(transformVersion, esbuild.Factory(), InternalPath, ReloadExport, mounts)
+		mfs.UseCache(dc, options)
+	}
+
+	roots := []string{}
+	for _, mount := range mounts {
+		roots = append(roots, mount.Dir)
+	}
+
 	var watcher *watcher.Watcher
 	var err error
-	watcher, err = newWriteWatcher(fs, serveRoot)
-	if err != nil {
-		panic(err)
+	if cfg.LazyWatch {
+		watcher = newLazyWatcher(fs)
+	} else {
+		watcher, err = newWriteWatcher(fs, roots...)
+		if err != nil {
+			panic(err)
+		}
 	}
 
-	mfs.Register(".js", ".jsx", func(fs afero.Fs, dst, src string) ([]byte, error) {
-		debug("building", dst)
-		return esbuild.BuildFile(fs, src)
-	})
+	var fallback string
+	if cfg.Fallback != "" {
+		fallback = path.Join("/", cfg.Fallback)
+	}
 
-	httpFs := afero.NewHttpFs(mfs).Dir(serveRoot)
-	prefix = path.Join("/", prefix)
-	return &Handler{
+	var upstreams []upstream
+	for _, proxy := range cfg.Proxies {
+		u, err := newUpstream(proxy)
+		if err != nil {
+			panic(err)
+		}
+		upstreams = append(upstreams, u)
+	}
+
+	m := &Handler{
 		Fs:         mfs,
 		ServeRoot:  serveRoot,
 		Prefix:     prefix,
+		Mounts:     mounts[1:],
+		Fallback:   fallback,
+		Templates:  cfg.Templates,
 		IgnoreDirs: cfg.IgnoreDirs,
 		Upgrader: websocket.Upgrader{
 			ReadBufferSize:  1024,
@@ -122,16 +205,72 @@ func New(cfg Config) *Handler {
 		},
 		Watcher:       watcher,
 		WatchInterval: cfg.WatchInterval,
-		fileserver:    http.StripPrefix(prefix, http.FileServer(httpFs)),
+		ReloadOnError: cfg.ReloadOnError,
+		PushSource:    cfg.PushSource,
+		Warmup:        cfg.Warmup,
+		LazyWatch:     cfg.LazyWatch,
+		upstreams:     upstreams,
+	}
+	m.registerTransforms()
+	return m
+}
+
+// mounts returns ServeRoot and any additional mounts, most specific
+// http path first.
+func (m *Handler) mounts() []Mount {
+	mounts := append([]Mount{{Dir: m.ServeRoot, Path: m.Prefix}}, m.Mounts...)
+	sort.SliceStable(mounts, func(i, j int) bool {
+		return len(mounts[i].Path) > len(mounts[j].Path)
+	})
+	return mounts
+}
+
+// mountFor returns the mount serving urlPath.
+func (m *Handler) mountFor(urlPath string) (Mount, bool) {
+	for _, mount := range m.mounts() {
+		if mount.Path == "/" || urlPath == mount.Path || strings.HasPrefix(urlPath, mount.Path+"/") {
+			return mount, true
+		}
+	}
+	return Mount{}, false
+}
+
+// fsPath maps a http path to its path in the filesystem.
+func (m *Handler) fsPath(urlPath string) (string, bool) {
+	mount, ok := m.mountFor(urlPath)
+	if !ok {
+		return "", false
+	}
+	return path.Join(mount.Dir, strings.TrimPrefix(urlPath, mount.Path)), true
+}
+
+// mountForFsPath returns the mount a path in the filesystem is under.
+func (m *Handler) mountForFsPath(fsPath string) Mount {
+	var match Mount
+	for _, mount := range m.mounts() {
+		if (fsPath == mount.Dir || strings.HasPrefix(fsPath, strings.TrimSuffix(mount.Dir, "/")+"/")) &&
+			len(mount.Dir) > len(match.Dir) {
+			match = mount
+		}
 	}
+	return match
+}
+
+// urlPath maps a path in the filesystem to the http path it is served at.
+func (m *Handler) urlPath(fsPath string) string {
+	match := m.mountForFsPath(fsPath)
+	return path.Join(match.Path, strings.TrimPrefix(fsPath, match.Dir))
 }
 
 func (m *Handler) MatchHTTP(r *http.Request) bool {
+	return m.matchFile(r) || m.upstreamFor(r) != nil || m.matchFallback(r)
+}
+
+func (m *Handler) matchFile(r *http.Request) bool {
 	if strings.HasPrefix(r.URL.Path, path.Join(m.Prefix, InternalPath)) {
 		return true
 	}
-	if strings.HasPrefix(r.URL.Path, m.Prefix) {
-		fsPath := path.Join(m.ServeRoot, strings.TrimPrefix(r.URL.Path, m.Prefix))
+	if fsPath, ok := m.fsPath(r.URL.Path); ok {
 		if ok, _ := afero.Exists(m.Fs, fsPath); ok {
 			return true
 		}
@@ -141,6 +280,10 @@ func (m *Handler) MatchHTTP(r *http.Request) bool {
 
 func (m *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	m.muxOnce.Do(m.buildMux)
+	if upstream := m.upstreamFor(r); upstream != nil {
+		upstream.ServeHTTP(w, r)
+		return
+	}
 	m.mux.ServeHTTP(w, r)
 }
 
@@ -148,11 +291,17 @@ func (m *Handler) buildMux() {
 	mux := http.NewServeMux()
 	mux.HandleFunc(path.Join(m.Prefix, InternalPath, ClientFilename), m.handleClientModule)
 	mux.HandleFunc(path.Join(m.Prefix, InternalPath), m.handleWebSocket)
+	mux.HandleFunc(path.Join(m.Prefix, InternalPath, EventsFilename), m.handleEvents)
 	if len(m.Prefix) > 1 {
 		mux.HandleFunc(m.Prefix+"/", m.handleFileProxy)
 	} else {
 		mux.HandleFunc(m.Prefix, m.handleFileProxy)
 	}
+	for _, mount := range m.Mounts {
+		if mount.Path != m.Prefix {
+			mux.HandleFunc(strings.TrimSuffix(mount.Path, "/")+"/", m.handleFileProxy)
+		}
+	}
 	m.mux = mux
 }
 
@@ -170,11 +319,53 @@ func (m *Handler) isIgnored(r *http.Request) bool {
 }
 
 func (m *Handler) handleFileProxy(w http.ResponseWriter, r *http.Request) {
+	if m.LazyWatch {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		defer func() { m.watchServed(r.URL.Path, sw.status) }()
+		w = sw
+	}
 	if m.isValidJS(r) && r.URL.RawQuery == "" {
 		m.handleModuleProxy(w, r)
 		return
 	}
-	m.fileserver.ServeHTTP(w, r)
+	if m.isValidJS(r) {
+		m.handleModuleSource(w, r)
+		return
+	}
+	if path.Ext(r.URL.Path) == ".css" && r.URL.RawQuery != "" {
+		query := r.URL.Query()
+		if _, ok := query["sheet"]; ok {
+			m.handleStyleModule(w, r, StyleSheetModuleTmpl, "sheet")
+			return
+		}
+		if _, ok := query["module"]; ok {
+			m.handleStyleModule(w, r, StyleModuleTmpl, "module")
+			return
+		}
+		m.handleStylesheet(w, r)
+		return
+	}
+	if m.isTemplatePage(r) {
+		fsPath, _ := m.fsPath(r.URL.Path)
+		m.servePage(w, r, fsPath)
+		return
+	}
+	if m.matchFallback(r) {
+		m.handleFallback(w, r)
+		return
+	}
+	mount, ok := m.mountFor(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if path.Ext(r.URL.Path) == ".css" {
+		fsPath, _ := m.fsPath(r.URL.Path)
+		m.trackStylesheetFile(fsPath)
+	}
+	m.preloadFile(w, r)
+	httpFs := afero.NewHttpFs(m.Fs).Dir(mount.Dir)
+	http.StripPrefix(mount.Path, http.FileServer(httpFs)).ServeHTTP(w, r)
 }
 
 func (m *Handler) handleClientModule(w http.ResponseWriter, r *http.Request) {
@@ -182,16 +373,24 @@ func (m *Handler) handleClientModule(w http.ResponseWriter, r *http.Request) {
 
 	w.Header().Set("content-type", "text/javascript")
 	tmpl.Execute(w, map[string]interface{}{
-		"Debug":    os.Getenv("HOTWEB_DEBUG") != "",
-		"Endpoint": fmt.Sprintf("ws://%s%s", r.Host, path.Dir(r.URL.Path)),
+		"Debug":          os.Getenv("HOTWEB_DEBUG") != "",
+		"Endpoint":       fmt.Sprintf("%s%s", r.Host, path.Dir(r.URL.Path)),
+		"Fallback":       m.Fallback,
+		"ReloadOnError":  m.ReloadOnError,
+		"EventsFilename": EventsFilename,
 	})
 }
 
 func (m *Handler) handleModuleProxy(w http.ResponseWriter, r *http.Request) {
 	tmpl := template.Must(template.New("proxy").Parse(ModuleProxyTmpl))
 
-	fsPath := path.Join(m.ServeRoot, strings.TrimPrefix(r.URL.Path, m.Prefix))
-	src, err := afero.ReadFile(m.Fs, fsPath)
+	fsPath, _ := m.fsPath(r.URL.Path)
+	src, err := m.readModule(fsPath)
+	if os.IsNotExist(err) {
+		http.NotFound(w, r)
+		debug(err)
+		return
+	}
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		debug(err)
@@ -209,11 +408,33 @@ func (m *Handler) handleModuleProxy(w http.ResponseWriter, r *http.Request) {
 	tmpl.Execute(w, map[string]interface{}{
 		"Path":       r.URL.Path,
 		"Exports":    exports,
+		"Version":    m.version(fsPath),
 		"Reload":     contains(exports, ReloadExport),
 		"ClientPath": path.Join(m.Prefix, InternalPath, ClientFilename),
 	})
 }
 
+// handleModuleSource serves the source of a module with imports of
+// non-JavaScript files pointed at generated modules and CommonJS
+// modules wrapped as ES modules.
+func (m *Handler) handleModuleSource(w http.ResponseWriter, r *http.Request) {
+	fsPath, _ := m.fsPath(r.URL.Path)
+	src, err := m.readModule(fsPath)
+	if err != nil {
+		http.NotFound(w, r)
+		debug(err)
+		return
+	}
+	src = rewriteModuleImports(src)
+	var modTime time.Time
+	if fi, err := m.Fs.Stat(fsPath); err == nil {
+		modTime = fi.ModTime()
+	}
+
+	w.Header().Set("content-type", "text/javascript")
+	http.ServeContent(w, r, path.Base(fsPath), modTime, bytes.NewReader(src))
+}
+
 func (m *Handler) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 	conn, err := m.Upgrader.Upgrade(w, r, nil)
 	if err != nil {
@@ -221,35 +442,170 @@ func (m *Handler) handleWebSocket(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	defer conn.Close()
-	ch := make(chan string)
-	m.clients.Store(ch, struct{}{})
+	c := newClient()
+	m.clients.Store(c, struct{}{})
+	defer func() {
+		m.clients.Delete(c)
+		c.close()
+	}()
 	debug("new websocket connection")
-
-	for filepath := range ch {
-		err := conn.WriteJSON(map[string]interface{}{
-			"path": path.Join(m.Prefix, strings.TrimPrefix(filepath, m.ServeRoot)),
-		})
-		if err != nil {
-			m.clients.Delete(ch)
-			if !strings.Contains(err.Error(), "broken pipe") {
-				debug(err)
+	go m.readClient(conn, c)
+
+	for {
+		select {
+		case msg := <-c.updates:
+			err := conn.WriteJSON(msg)
+			if err != nil {
+				if !strings.Contains(err.Error(), "broken pipe") {
+					debug(err)
+				}
+				return
 			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// clientMsg is sent by clients to subscribe to paths and acknowledge
+// updates.
+type clientMsg struct {
+	Type     string            `json:"type"`
+	ID       int64             `json:"id"`
+	Paths    []string          `json:"paths"`
+	Origin   string            `json:"origin"`
+	Errors   map[string]string `json:"errors"`
+	Duration float64           `json:"duration"` // milliseconds
+}
+
+// Ack is how a client applied an update, passed to OnAck.
+type Ack struct {
+	ID       int64             // id of the update
+	Paths    []string          // http paths of changed files the client updated
+	Errors   map[string]string // errors by path, or "" for refresh callbacks
+	Duration time.Duration     // time taken to apply the update
+}
+
+// OK reports whether the update was applied without errors.
+func (a Ack) OK() bool {
+	return len(a.Errors) == 0
+}
+
+// readClient handles the messages sent by a websocket client until it
+// disconnects, closing the client.
+func (m *Handler) readClient(conn *websocket.Conn, c *client) {
+	for {
+		var msg clientMsg
+		if err := conn.ReadJSON(&msg); err != nil {
+			c.close()
 			return
 		}
+		m.handleClientMsg(c, msg)
 	}
 }
 
+// handleClientMsg subscribes a client to paths, or logs a failed update
+// and passes its acknowledgement to OnAck.
+func (m *Handler) handleClientMsg(c *client, msg clientMsg) {
+	switch msg.Type {
+	case "subscribe":
+		c.subscribe(msg.Paths, msg.Origin)
+	case "ack":
+		ack := Ack{
+			ID:       msg.ID,
+			Paths:    msg.Paths,
+			Errors:   msg.Errors,
+			Duration: time.Duration(msg.Duration * float64(time.Millisecond)),
+		}
+		for p, err := range ack.Errors {
+			if p == "" {
+				log.Printf("hotweb: update %d: refresh failed: %s\n", ack.ID, err)
+			} else {
+				log.Printf("hotweb: update %d: failed to reload %s: %s\n", ack.ID, p, err)
+			}
+		}
+		debug("update", ack.ID, "applied", ack.Paths, "in", ack.Duration)
+		if m.OnAck != nil {
+			m.OnAck(ack)
+		}
+	}
+}
+
+// updateMsg is sent to clients with the files changed by an event to be
+// applied together and acknowledged.
+type updateMsg struct {
+	ID      int64       `json:"id"`
+	Changes []changeMsg `json:"changes"`
+}
+
+// changeMsg is sent to clients when a watched file changes.
+type changeMsg struct {
+	Path    string   `json:"path"`
+	Version string   `json:"version,omitempty"` // content version of the changed file
+	Styles  []string `json:"styles,omitempty"`  // stylesheets affected by a css change
+	Source  string   `json:"source,omitempty"`  // module source when pushing source
+}
+
+func (m *Handler) changeMsg(fsPath string) changeMsg {
+	msg := changeMsg{Path: m.urlPath(fsPath), Version: m.version(fsPath)}
+	if path.Ext(fsPath) == ".css" {
+		m.trackStylesheetFile(fsPath)
+		msg.Styles = m.cssDependents(msg.Path)
+	}
+	if m.PushSource {
+		msg.Source, _ = m.pushSource(fsPath, msg.Path)
+	}
+	return msg
+}
+
+// version returns the content version of a file, or an empty string if
+// it can't be read. Template pages are versioned by their rendered output
+// so editing their includes or page data changes it.
+func (m *Handler) version(fsPath string) string {
+	var b []byte
+	var err error
+	if m.isTemplateFile(fsPath) {
+		b, err = m.renderPage(fsPath)
+	} else {
+		b, err = afero.ReadFile(m.Fs, fsPath)
+	}
+	if err != nil {
+		return ""
+	}
+	return contentVersion(b)
+}
+
+// contentVersion is a short hash of file contents used in urls so they
+// only change when the contents do.
+func contentVersion(b []byte) string {
+	sum := sha1.Sum(b)
+	return hex.EncodeToString(sum[:])[:8]
+}
+
 func (m *Handler) Watch() error {
 	if m.Watcher == nil {
 		return fmt.Errorf("hotweb: no watcher to watch filesystem")
 	}
+	// warming up walks every mount, which lazy watching avoids
+	if m.Warmup > 0 && !m.LazyWatch {
+		go m.warm()
+	}
 	go func() {
 		for {
 			select {
 			case event := <-m.Watcher.Event:
 				debug("detected change", event.Path)
+				m.updates++
+				update := updateMsg{ID: m.updates}
+				// files made from the changed file changed too
+				for _, p := range append([]string{event.Path}, m.Fs.Derived(event.Path)...) {
+					update.Changes = append(update.Changes, m.changeMsg(p))
+				}
 				m.clients.Range(func(k, v interface{}) bool {
-					k.(chan string) <- event.Path
+					c := k.(*client)
+					if update, ok := c.filter(update); ok {
+						c.send(update)
+					}
 					return true
 				})
 			case err := <-m.Watcher.Error:
@@ -262,8 +618,34 @@ func (m *Handler) Watch() error {
 	return m.Watcher.Start(m.WatchInterval)
 }
 
+// warm builds the files made from files in the mounts ahead of them
+// being requested.
+func (m *Handler) warm() {
+	var names []string
+	for _, mount := range m.mounts() {
+		afero.Walk(m.Fs.Fs, mount.Dir, func(fsPath string, fi os.FileInfo, err error) error {
+			if err != nil {
+				return nil
+			}
+			r := &http.Request{URL: &url.URL{Path: m.urlPath(fsPath)}}
+			if fi.IsDir() && fsPath != mount.Dir && (fi.Name()[0] == '.' || m.isIgnored(r)) {
+				return filepath.SkipDir
+			}
+			if !fi.IsDir() {
+				names = append(names, fsPath)
+			}
+			return nil
+		})
+	}
+	start := time.Now()
+	for name, err := range m.Fs.Warm(names, m.Warmup) {
+		debug(name, err)
+	}
+	debug("warmed up in", time.Since(start))
+}
+
 func isJavaScript(r *http.Request) bool {
-	return contains([]string{".mjs", ".js", ".jsx"}, path.Ext(r.URL.Path))
+	return contains([]string{".mjs", ".js", ".jsx", ".cjs"}, path.Ext(r.URL.Path))
 }
 
 func hiddenFilePrefix(r *http.Request) bool {
@@ -1,10 +1,16 @@
 package hotweb
 
 import (
+	"bufio"
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/gorilla/websocket"
+	"github.com/progrium/hotweb/pkg/cssmodules"
 	"github.com/spf13/afero"
 )
 
@@ -148,4 +154,859 @@ func TestHotweb(t *testing.T) {
 		}
 	})
 
+	if err := afero.WriteFile(f, "/shared/util.js", existFile, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hwm := New(Config{
+		Filesystem: f,
+		ServeRoot:  "/root",
+		Mounts: []Mount{
+			{Dir: "/shared", Path: "/shared"},
+		},
+	})
+
+	t.Run("existing file, no proxy, mounted", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/shared/util.js?0", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		match := hwm.MatchHTTP(req)
+		if !match {
+			t.Fatal("no match")
+		}
+
+		hwm.ServeHTTP(rr, req)
+		expected := string(existFile)
+		if rr.Body.String() != expected {
+			t.Errorf("got %v want %v", rr.Body.String(), expected)
+		}
+	})
+
+	t.Run("change path, mounted", func(t *testing.T) {
+		got := hwm.urlPath("/shared/util.js")
+		expected := "/shared/util.js"
+		if got != expected {
+			t.Errorf("got %v want %v", got, expected)
+		}
+		got = hwm.urlPath("/root/sub/exists")
+		expected = "/sub/exists"
+		if got != expected {
+			t.Errorf("got %v want %v", got, expected)
+		}
+	})
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if websocket.IsWebSocketUpgrade(r) {
+			conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+			conn.WriteMessage(websocket.TextMessage, []byte("backend "+r.URL.Path))
+			return
+		}
+		w.Write([]byte("backend " + r.URL.Path))
+	}))
+	defer backend.Close()
+
+	hwu := New(Config{
+		Filesystem: f,
+		ServeRoot:  "/root",
+		Proxies: []Proxy{
+			{Path: "/sub", Target: backend.URL},
+		},
+	})
+
+	t.Run("missing file, upstream proxy", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/sub/api", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		match := hwu.MatchHTTP(req)
+		if !match {
+			t.Fatal("no match")
+		}
+
+		hwu.ServeHTTP(rr, req)
+		expected := "backend /sub/api"
+		if rr.Body.String() != expected {
+			t.Errorf("got %v want %v", rr.Body.String(), expected)
+		}
+	})
+
+	t.Run("existing file, upstream proxy", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/sub/exists", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hwu.ServeHTTP(rr, req)
+		expected := string(existFile)
+		if rr.Body.String() != expected {
+			t.Errorf("got %v want %v", rr.Body.String(), expected)
+		}
+	})
+
+	t.Run("websocket, upstream proxy", func(t *testing.T) {
+		srv := httptest.NewServer(hwu)
+		defer srv.Close()
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/sub/socket", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "backend /sub/socket"
+		if string(msg) != expected {
+			t.Errorf("got %v want %v", string(msg), expected)
+		}
+	})
+
+	t.Run("invalid upstream target", func(t *testing.T) {
+		for _, target := range []string{"localhost:3000", "/api", "http://"} {
+			if _, err := newUpstream(Proxy{Path: "/api", Target: target}); err == nil {
+				t.Errorf("got no error for target %q", target)
+			}
+		}
+	})
+
+	if err := afero.WriteFile(f, "/root/index.html", []byte("<html></html>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	hwf := New(Config{
+		Filesystem: f,
+		ServeRoot:  "/root",
+		Fallback:   "/index.html",
+	})
+
+	t.Run("client-side route, fallback", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/dashboard/settings", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "text/html,*/*")
+
+		rr := httptest.NewRecorder()
+		match := hwf.MatchHTTP(req)
+		if !match {
+			t.Fatal("no match")
+		}
+
+		hwf.ServeHTTP(rr, req)
+		expected := "<html></html>"
+		if rr.Body.String() != expected {
+			t.Errorf("got %v want %v", rr.Body.String(), expected)
+		}
+	})
+
+	t.Run("missing asset, no fallback", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/dashboard/missing.js", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept", "text/html,*/*")
+
+		if hwf.MatchHTTP(req) {
+			t.Fatal("unexpected match")
+		}
+	})
+
+	if err := afero.WriteFile(f, "/root/main.css", []byte("@import url(https://example.com/x.css);\n@import \"/style/hero.css\";\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(f, "/root/style/hero.css", []byte("@import 'fonts.css';\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(f, "/root/style/fonts.css", []byte("body {}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("css import dependents", func(t *testing.T) {
+		got := strings.Join(hw.changeMsg("/root/style/fonts.css").Styles, ",")
+		expected := "/style/fonts.css"
+		if got != expected {
+			t.Errorf("got %v want only the changed stylesheet before serving", got)
+		}
+		for _, p := range []string{"/main.css", "/style/hero.css?123"} {
+			req, err := http.NewRequest("GET", p, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			hw.ServeHTTP(httptest.NewRecorder(), req)
+		}
+		got = strings.Join(hw.changeMsg("/root/style/fonts.css").Styles, ",")
+		expected = "/main.css,/style/fonts.css,/style/hero.css"
+		if got != expected {
+			t.Errorf("got %v want %v", got, expected)
+		}
+
+		if err := afero.WriteFile(f, "/root/style/hero.css", []byte("body {}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		hw.changeMsg("/root/style/hero.css")
+		got = strings.Join(hw.changeMsg("/root/style/fonts.css").Styles, ",")
+		expected = "/style/fonts.css"
+		if got != expected {
+			t.Errorf("got %v want import removed by change", got)
+		}
+		if err := afero.WriteFile(f, "/root/style/hero.css", []byte("@import 'fonts.css';\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		hw.changeMsg("/root/style/hero.css")
+	})
+
+	t.Run("versioned css imports", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/main.css?123", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		expected := "@import url(https://example.com/x.css);\n@import \"/style/hero.css?123\";\n"
+		if rr.Body.String() != expected {
+			t.Errorf("got %v want %v", rr.Body.String(), expected)
+		}
+	})
+
+	if err := afero.WriteFile(f, "/root/styled.js", []byte("import './style/hero.css';\nimport sheet from \"./main.css\" with { type: 'css' };\nimport * as x from '/exists.js';\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("css imports, module source", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/styled.js?0", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		expected := "import './style/hero.css?module';\nimport sheet from \"./main.css?sheet\";\nimport * as x from '/exists.js';\n"
+		if rr.Body.String() != expected {
+			t.Errorf("got %v want %v", rr.Body.String(), expected)
+		}
+	})
+
+	if err := afero.WriteFile(f, "/root/literals.js", []byte("// import './a.css';\nconst s = \"import x from './b.css'\";\nconst re = /import '.\\/c.css'/;\nimport './d.css';\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("imports in literals, module source", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/literals.js?0", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		expected := "// import './a.css';\nconst s = \"import x from './b.css'\";\nconst re = /import '.\\/c.css'/;\nimport './d.css?module';\n"
+		if rr.Body.String() != expected {
+			t.Errorf("got %v want %v", rr.Body.String(), expected)
+		}
+	})
+
+	t.Run("conditional request, module source", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/literals.js?0", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		lastModified := rr.Header().Get("Last-Modified")
+		if lastModified == "" {
+			t.Fatal("no Last-Modified header")
+		}
+		req.Header.Set("If-Modified-Since", lastModified)
+		rr = httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNotModified {
+			t.Errorf("got status %d want %d", rr.Code, http.StatusNotModified)
+		}
+	})
+
+	t.Run("css import, style module", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/style/hero.css?module", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		expected := `hotweb.injectStyle("/style/hero.css", "@import '/style/fonts.css';\n", "/style/hero.css?module");`
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("got %v want %v", rr.Body.String(), expected)
+		}
+	})
+
+	t.Run("module proxy, failed reload", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/exists.js", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		expected := "let newMod = await hotweb.tryImport('/exists.js', \"/exists.js?v=\"+ts, msg.source);\n\tif (newMod === undefined) {\n\t\treturn;\n\t}\n"
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("got %v want %v", rr.Body.String(), expected)
+		}
+	})
+
+	t.Run("module proxy, content version", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/exists.js", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		for _, expected := range []string{
+			"import * as mod from '/exists.js?v=0beec7b5';",
+			"let version = '0beec7b5';",
+			"}, version);",
+		} {
+			if !strings.Contains(rr.Body.String(), expected) {
+				t.Errorf("got %v want %v", rr.Body.String(), expected)
+			}
+		}
+		if msg := hw.changeMsg("/root/exists.js"); msg.Version != "0beec7b5" {
+			t.Errorf("got %v want %v", msg.Version, "0beec7b5")
+		}
+	})
+
+	if err := afero.WriteFile(f, "/root/card.module.css", []byte(".title { color: red; }\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("css module, module proxy", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/card.module.js", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		expected := "export {\n\tdefaultProxy as default,\n"
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("got %v want %v", rr.Body.String(), expected)
+		}
+	})
+
+	t.Run("css module, module source", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/card.module.js?0", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		scoped := cssmodules.ScopedName("title", "/card.module.css")
+		for _, expected := range []string{
+			`hotweb.injectStyle("/card.module.css", ".` + scoped + ` { color: red; }\n");`,
+			`"title": "` + scoped + `"`,
+		} {
+			if !strings.Contains(rr.Body.String(), expected) {
+				t.Errorf("got %v want %v", rr.Body.String(), expected)
+			}
+		}
+	})
+
+	t.Run("css module, derived change", func(t *testing.T) {
+		got := strings.Join(hw.Fs.Derived("/root/card.module.css"), ",")
+		expected := "/root/card.module.js"
+		if got != expected {
+			t.Errorf("got %v want %v", got, expected)
+		}
+	})
+
+	if err := afero.WriteFile(f, "/root/cjs/util.js", []byte("exports.add = (a, b) => a + b;\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(f, "/root/cjs/main.js", []byte("const util = require('./util');\nmodule.exports = {\n\trun() { return util.add(1, 2); },\n\tname: \"main\",\n};\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("commonjs, module proxy", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/cjs/main.js", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		for _, expected := range []string{"defaultProxy as default,", "nameProxy as name,", "runProxy as run,"} {
+			if !strings.Contains(rr.Body.String(), expected) {
+				t.Errorf("got %v want %v", rr.Body.String(), expected)
+			}
+		}
+	})
+
+	t.Run("commonjs, module source", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/cjs/main.js?0", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		for _, expected := range []string{
+			"import * as __hotweb_require0 from '/cjs/util.js';",
+			"\"./util\": __hotweb_require0.default,",
+			"export const run = __hotweb_module.exports.run;",
+		} {
+			if !strings.Contains(rr.Body.String(), expected) {
+				t.Errorf("got %v want %v", rr.Body.String(), expected)
+			}
+		}
+	})
+
+	t.Run("commonjs, detection", func(t *testing.T) {
+		for src, expected := range map[string]bool{
+			"const a=require;export{a};":                        false,
+			"var b=1;import{c}from'./c.js';c(require('d'));":    false,
+			"// import x from 'y'\nmodule.exports = 1;\n":       true,
+			"const s = \"export default\";\nexports.s = s;\n":   true,
+			"module.exports.export = 1;\nexports.import = 2;\n": true,
+		} {
+			if got := isCommonJS("/main.js", []byte(src)); got != expected {
+				t.Errorf("got %v want %v for %q", got, expected, src)
+			}
+		}
+		got := strings.Join(cjsExports([]byte("exports.module = 1;\nexports.requires = 2;\nexports.__hotweb_module = 3;\n")), ",")
+		if got != "module,requires" {
+			t.Errorf("got %v want module,requires", got)
+		}
+	})
+
+	if err := afero.WriteFile(f, "/root/data/config.yaml", []byte("title: Hello\nitems:\n  - 1\n  - 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(f, "/root/data/uses.js", []byte("import config from './config.yaml';\nimport pkg from \"./package.json\" with { type: 'json' };\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("data imports, module source", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/data/uses.js?0", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		expected := "import config from './config.yaml.js';\nimport pkg from \"./package.json.js\";\n"
+		if rr.Body.String() != expected {
+			t.Errorf("got %v want %v", rr.Body.String(), expected)
+		}
+	})
+
+	t.Run("data module, module source", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/data/config.yaml.js?0", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		for _, expected := range []string{
+			"\"title\": \"Hello\"",
+			"export const items = __data.items;",
+			"export const title = __data.title;",
+		} {
+			if !strings.Contains(rr.Body.String(), expected) {
+				t.Errorf("got %v want %v", rr.Body.String(), expected)
+			}
+		}
+	})
+
+	if err := afero.WriteFile(f, "/root/icons/check.svg", []byte(`<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 24 24"><title>Check</title><path d="M5 12l5 5"/></svg>`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("svg component, module source", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/icons/check.svg.js?0", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		expected := `m("svg", Object.assign({"viewBox":"0 0 24 24"}, props), m("title", {}, "Check"), m("path", {"d":"M5 12l5 5"}))`
+		if !strings.Contains(rr.Body.String(), expected) {
+			t.Errorf("got %v want %v", rr.Body.String(), expected)
+		}
+	})
+
+	t.Run("svg component, react props", func(t *testing.T) {
+		src := []byte(`<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" class="icon" data-name="x"><use xlink:href="#a" stroke-width="2" xml:space="preserve"/></svg>`)
+		got, err := svgElement("React.createElement", src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := `React.createElement("svg", Object.assign({"className":"icon","data-name":"x","xmlnsXlink":"http://www.w3.org/1999/xlink"}, props), React.createElement("use", {"strokeWidth":"2","xlinkHref":"#a","xmlSpace":"preserve"}))`
+		if got != expected {
+			t.Errorf("got %v want %v", got, expected)
+		}
+	})
+
+	t.Run("svg url, module source", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/icons/check.svg.url.js?0", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		expected := `export default "/icons/check.svg?`
+		if !strings.HasPrefix(rr.Body.String(), expected) {
+			t.Errorf("got %v want %v", rr.Body.String(), expected)
+		}
+	})
+	if err := afero.WriteFile(f, "/root/docs/_layout.html", []byte("<title>{{.Title}}</title>\n<main>{{.Content}}</main>\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(f, "/root/docs/guide/intro.md", []byte("---\ntitle: Intro\n---\n# Getting started\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("markdown page, nearest layout", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/docs/guide/intro.html", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		if !hw.MatchHTTP(req) {
+			t.Fatal("no match")
+		}
+		hw.ServeHTTP(rr, req)
+		expected := "<title>Intro</title>\n<main><h1>Getting started</h1>\n</main>\n"
+		if rr.Body.String() != expected {
+			t.Errorf("got %v want %v", rr.Body.String(), expected)
+		}
+		derived := hw.Fs.Derived("/root/docs/_layout.html")
+		if len(derived) != 1 || derived[0] != "/root/docs/guide/intro.html" {
+			t.Errorf("got %v want layout dependent", derived)
+		}
+		derived = hw.Fs.Derived("/root/docs/guide/_layout.html")
+		if len(derived) != 1 || derived[0] != "/root/docs/guide/intro.html" {
+			t.Errorf("got %v want missing nearer layout dependent", derived)
+		}
+	})
+	if err := afero.WriteFile(f, "/root/cycle/_layout.html", []byte("{{include .Page.next}}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(f, "/root/cycle/a.md", []byte("---\nnext: b.html\n---\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(f, "/root/cycle/b.md", []byte("---\nnext: a.html\n---\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("markdown page, include cycle", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/cycle/a.html", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		done := make(chan struct{})
+		go func() {
+			hw.ServeHTTP(rr, req)
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("deadlocked rendering include cycle")
+		}
+		if !strings.Contains(rr.Body.String(), "depends on itself") {
+			t.Errorf("got %v want dependency cycle error", rr.Body.String())
+		}
+	})
+
+	if err := afero.WriteFile(f, "/root/site/_header.html", []byte("<h1>{{.title}}</h1>"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(f, "/root/site/about.html", []byte("{{include \"_header.html\" .}}\n<p>{{.body}}</p>\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(f, "/root/site/about.yaml", []byte("title: About\nbody: <hi>\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	pages := New(Config{
+		Filesystem: f,
+		ServeRoot:  "/root",
+		Templates:  true,
+	})
+
+	t.Run("template page, include and data", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/site/about.html", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		pages.ServeHTTP(rr, req)
+		expected := "<h1>About</h1>\n<p>&lt;hi&gt;</p>\n"
+		if rr.Body.String() != expected {
+			t.Errorf("got %v want %v", rr.Body.String(), expected)
+		}
+		derived := pages.Fs.Derived("/root/site/_header.html")
+		if len(derived) != 1 || derived[0] != "/root/site/about.html" {
+			t.Errorf("got %v want include dependent", derived)
+		}
+	})
+
+	t.Run("template page, include version", func(t *testing.T) {
+		before := pages.changeMsg("/root/site/about.html").Version
+		if err := afero.WriteFile(f, "/root/site/_header.html", []byte("<h2>{{.title}}</h2>"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		after := pages.changeMsg("/root/site/about.html").Version
+		if before == "" || before == after {
+			t.Errorf("got version %v before and %v after include edit", before, after)
+		}
+		if err := afero.WriteFile(f, "/root/site/_header.html", []byte("<h1>{{.title}}</h1>"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("template page, removed include", func(t *testing.T) {
+		if err := afero.WriteFile(f, "/root/site/contact.html", []byte("{{include \"_header.html\" .}}\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pages.renderPage("/root/site/contact.html"); err != nil {
+			t.Fatal(err)
+		}
+		if err := afero.WriteFile(f, "/root/site/contact.html", []byte("<p>Contact</p>\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pages.renderPage("/root/site/contact.html"); err != nil {
+			t.Fatal(err)
+		}
+		derived := pages.Fs.Derived("/root/site/_header.html")
+		if len(derived) != 1 || derived[0] != "/root/site/about.html" {
+			t.Errorf("got %v want only the page still including it", derived)
+		}
+	})
+
+	t.Run("template page, disabled", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/site/about.html", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		if !strings.Contains(rr.Body.String(), "{{include") {
+			t.Errorf("got %v want unrendered page", rr.Body.String())
+		}
+	})
+	t.Run("update acknowledgement", func(t *testing.T) {
+		acks := make(chan Ack, 1)
+		hw.OnAck = func(ack Ack) {
+			acks <- ack
+		}
+		srv := httptest.NewServer(hw)
+		defer srv.Close()
+
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+InternalPath, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		err = conn.WriteJSON(map[string]interface{}{
+			"type":     "ack",
+			"id":       3,
+			"paths":    []string{"/exists.js"},
+			"errors":   map[string]string{"/exists.js": "SyntaxError"},
+			"duration": 1.5,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case ack := <-acks:
+			if ack.ID != 3 || ack.OK() || ack.Errors["/exists.js"] != "SyntaxError" || ack.Duration != 1500*time.Microsecond {
+				t.Errorf("got %+v", ack)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("no ack")
+		}
+	})
+	t.Run("client subscriptions", func(t *testing.T) {
+		update := updateMsg{ID: 1, Changes: []changeMsg{
+			{Path: "/lib/app.js"},
+			{Path: "/theme.css", Styles: []string{"/main.css", "/theme.css"}},
+			{Path: "/other.js"},
+		}}
+		c := newClient()
+		if got, ok := c.filter(update); !ok || len(got.Changes) != 3 {
+			t.Errorf("got %v want every change before subscribing", got.Changes)
+		}
+		c.subscribe([]string{"/lib/*", "/main.css"}, "")
+		got, ok := c.filter(update)
+		if !ok || len(got.Changes) != 2 || got.Changes[0].Path != "/lib/app.js" || got.Changes[1].Path != "/theme.css" {
+			t.Errorf("got %v want subscribed changes", got.Changes)
+		}
+		c.subscribe([]string{"/index.html"}, "")
+		if _, ok := c.filter(updateMsg{ID: 2, Changes: []changeMsg{{Path: "/other.js"}}}); ok {
+			t.Error("got update with no subscribed changes")
+		}
+	})
+	t.Run("event stream", func(t *testing.T) {
+		srv := httptest.NewServer(hw)
+		defer srv.Close()
+
+		resp, err := http.Get(srv.URL + InternalPath + "/" + EventsFilename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		events := bufio.NewReader(resp.Body)
+		readEvent := func() string {
+			var event string
+			for {
+				line, err := events.ReadString('\n')
+				if err != nil {
+					t.Fatal(err)
+				}
+				if line == "\n" {
+					return event
+				}
+				event += line
+			}
+		}
+		hello := readEvent()
+		var data struct{ ID string }
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(strings.Split(hello, "\n")[1], "data: ")), &data); err != nil {
+			t.Fatalf("got %v: %v", hello, err)
+		}
+
+		post, err := http.Post(srv.URL+InternalPath+"/"+EventsFilename+"?id="+data.ID, "application/json",
+			strings.NewReader(`{"type":"subscribe","paths":["/exists.js"]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		post.Body.Close()
+		if post.StatusCode != http.StatusNoContent {
+			t.Fatalf("got status %v", post.StatusCode)
+		}
+
+		hw.clients.Range(func(k, v interface{}) bool {
+			c := k.(*client)
+			if c.id == data.ID {
+				update, ok := c.filter(updateMsg{ID: 7, Changes: []changeMsg{{Path: "/other.js"}, {Path: "/exists.js"}}})
+				if ok {
+					go c.send(update)
+				}
+			}
+			return true
+		})
+		expected := "data: {\"id\":7,\"changes\":[{\"path\":\"/exists.js\"}]}\n"
+		if got := readEvent(); got != expected {
+			t.Errorf("got %v want %v", got, expected)
+		}
+	})
+	if err := afero.WriteFile(f, "/root/push/app.js", []byte("import {a} from './lib.js';\nimport config from '/data/config.yaml';\nimport m from 'mithril';\nconst url = import.meta.url;\nimport('../exists.js');\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("pushed module source", func(t *testing.T) {
+		hw.PushSource = true
+		defer func() {
+			hw.PushSource = false
+		}()
+		update := updateMsg{ID: 1, Changes: []changeMsg{hw.changeMsg("/root/push/app.js")}}
+		c := newClient()
+		if got, _ := c.filter(update); got.Changes[0].Source != "" {
+			t.Errorf("got %v want no source without origin", got.Changes[0].Source)
+		}
+		c.subscribe([]string{"/push/*"}, "http://localhost:8080")
+		got, _ := c.filter(update)
+		expected := "import {a} from 'http://localhost:8080/push/lib.js';\n" +
+			"import config from 'http://localhost:8080/data/config.yaml.js';\n" +
+			"import m from 'mithril';\n" +
+			"const url = \"http://localhost:8080/push/app.js\";\n" +
+			"import('http://localhost:8080/exists.js');\n"
+		if got.Changes[0].Source != expected {
+			t.Errorf("got %v want %v", got.Changes[0].Source, expected)
+		}
+	})
+	for name, src := range map[string]string{
+		"/root/preload/index.html": "<script type=\"module\" src=\"main.js\"></script>\n<script type=\"module\" src=\"https://cdn.example.com/lib.js\"></script>\n<script type=\"module\">import './inline.js';</script>\n",
+		"/root/preload/main.js":    "import './dep.js';\nimport './style.css';\n",
+		"/root/preload/inline.js":  "export const a = 1;\n",
+		"/root/preload/dep.js":     "import '/exists.js';\n",
+		"/root/preload/style.css":  "body {}\n",
+	} {
+		if err := afero.WriteFile(f, name, []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	t.Run("module preloads", func(t *testing.T) {
+		req, err := http.NewRequest("GET", "/preload/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		hw.ServeHTTP(rr, req)
+		var got []string
+		for _, link := range strings.Split(rr.Header().Get("Link"), ", ") {
+			got = append(got, strings.SplitN(strings.TrimSuffix(link, ">; rel=modulepreload"), "?", 2)[0]+"?")
+		}
+		expected := []string{
+			"</preload/main.js?", "</.hotweb/client.mjs?", "</preload/main.js?",
+			"</preload/inline.js?", "</preload/inline.js?",
+			"</preload/dep.js?", "</preload/dep.js?",
+			"</preload/style.css?",
+			"</exists.js?", "</exists.js?",
+		}
+		if strings.Join(got, " ") != strings.Join(expected, " ") {
+			t.Errorf("got %v want %v", rr.Header().Get("Link"), expected)
+		}
+		if !strings.Contains(rr.Header().Get("Link"), "</exists.js?v=0beec7b5>; rel=modulepreload") {
+			t.Errorf("got %v want versioned source", rr.Header().Get("Link"))
+		}
+	})
+
+	lazy := New(Config{
+		Filesystem: f,
+		ServeRoot:  "/root",
+		LazyWatch:  true,
+	})
+
+	t.Run("lazy watching", func(t *testing.T) {
+		for _, p := range []string{"/exists.js", "/html.js", "/sub/missing.js"} {
+			req, err := http.NewRequest("GET", p, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			lazy.ServeHTTP(httptest.NewRecorder(), req)
+		}
+		watched := lazy.Watcher.WatchedFiles()
+		for _, p := range []string{"/root/exists.js", "/root/html.jsx", "/root/sub", "/root/sub/exists"} {
+			if _, ok := watched[p]; !ok {
+				t.Errorf("%s not watched", p)
+			}
+		}
+		if _, ok := watched["/root/site/about.html"]; ok {
+			t.Error("unserved file watched")
+		}
+	})
+
 }
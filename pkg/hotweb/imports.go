@@ -0,0 +1,232 @@
+package hotweb
+
+import (
+	"bytes"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// importExpr matches static import and export-from statements, capturing
+// the import clause, the quoted specifier and any import attributes.
+var importExpr = regexp.MustCompile(`\b(import|export)(\s*[\w$*{}\s,]*?\s*(?:\bfrom\s*)?)(["'])([^"'\n]+)["'](\s*(?:with|assert)\s*\{[^}]*\})?`)
+
+var fromExpr = regexp.MustCompile(`\bfrom\s*$`)
+
+var cssTypeExpr = regexp.MustCompile(`type\s*:\s*["']css["']`)
+
+type moduleImport struct {
+	Clause string // everything between import and the specifier
+	Spec   string
+	Attrs  string // import attributes, eg with {type: 'css'}
+}
+
+// rewriteImports replaces the specifier of imports in src for which fn
+// returns a new one. Import attributes are dropped from rewritten imports.
+func rewriteImports(src []byte, fn func(imp moduleImport) (string, bool)) []byte {
+	return replaceImports(src, fn, false)
+}
+
+// resolveImports is like rewriteImports but keeps import attributes.
+func resolveImports(src []byte, fn func(imp moduleImport) (string, bool)) []byte {
+	return replaceImports(src, fn, true)
+}
+
+func replaceImports(src []byte, fn func(imp moduleImport) (string, bool), keepAttrs bool) []byte {
+	literals := literalSpans(src)
+	var out []byte
+	last := 0
+	for _, loc := range importExpr.FindAllSubmatchIndex(src, -1) {
+		if inSpans(literals, loc[0]) {
+			continue
+		}
+		group := func(i int) string {
+			if loc[2*i] < 0 {
+				return ""
+			}
+			return string(src[loc[2*i]:loc[2*i+1]])
+		}
+		if group(1) == "export" && !fromExpr.MatchString(group(2)) {
+			continue
+		}
+		spec, ok := fn(moduleImport{
+			Clause: group(2),
+			Spec:   group(4),
+			Attrs:  group(5),
+		})
+		if !ok {
+			continue
+		}
+		quote := group(3)
+		stmt := group(1) + group(2) + quote + spec + quote
+		if keepAttrs {
+			stmt += group(5)
+		}
+		out = append(append(out, src[last:loc[0]]...), stmt...)
+		last = loc[1]
+	}
+	if last == 0 {
+		return src
+	}
+	return append(out, src[last:]...)
+}
+
+// literalSpans returns the start and end offsets of the comments, strings,
+// template literals and regular expressions in JavaScript source, where
+// text that looks like an import isn't one.
+func literalSpans(src []byte) [][2]int {
+	var spans [][2]int
+	prev := byte(0) // last non-space byte of code
+	for i := 0; i < len(src); i++ {
+		start := i
+		switch c := src[i]; {
+		case c == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '/' && i+1 < len(src) && src[i+1] == '*':
+			end := bytes.Index(src[i+2:], []byte("*/"))
+			if end < 0 {
+				i = len(src)
+			} else {
+				i += end + 4
+			}
+		case c == '"' || c == '\'' || c == '`':
+			i = skipQuoted(src, i, c)
+		case c == '/' && (prev == 0 || strings.IndexByte("(,=:[!&|?{};+-*%<>~^", prev) >= 0):
+			i = skipRegexp(src, i)
+		default:
+			if c != ' ' && c != '\t' && c != '\n' && c != '\r' {
+				prev = c
+			}
+			continue
+		}
+		spans = append(spans, [2]int{start, i})
+		prev = '"'
+		i--
+	}
+	return spans
+}
+
+// skipQuoted returns the offset after the string or template literal
+// starting at i.
+func skipQuoted(src []byte, i int, quote byte) int {
+	for i++; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case quote:
+			return i + 1
+		case '\n':
+			if quote != '`' {
+				return i
+			}
+		}
+	}
+	return len(src)
+}
+
+// skipRegexp returns the offset after the regular expression literal
+// starting at i.
+func skipRegexp(src []byte, i int) int {
+	class := false
+	for i++; i < len(src); i++ {
+		switch src[i] {
+		case '\\':
+			i++
+		case '[':
+			class = true
+		case ']':
+			class = false
+		case '/':
+			if !class {
+				return i + 1
+			}
+		case '\n':
+			return i
+		}
+	}
+	return len(src)
+}
+
+// inSpans reports whether offset i is within one of the sorted spans.
+func inSpans(spans [][2]int, i int) bool {
+	n := sort.Search(len(spans), func(j int) bool {
+		return spans[j][1] > i
+	})
+	return n < len(spans) && spans[n][0] <= i
+}
+
+// rewriteModuleImports points imports of non-JavaScript files in a
+// module at generated modules.
+func rewriteModuleImports(src []byte) []byte {
+	return rewriteImports(src, func(imp moduleImport) (string, bool) {
+		for _, rewrite := range []func(moduleImport) (string, bool){
+			rewriteStyleImports,
+			rewriteDataImports,
+			rewriteSVGImports,
+		} {
+			if spec, ok := rewrite(imp); ok {
+				return spec, true
+			}
+		}
+		return "", false
+	})
+}
+
+// rewriteStyleImports points imports of stylesheets at generated modules,
+// ?sheet for CSS module scripts and ?module for everything else.
+func rewriteStyleImports(imp moduleImport) (string, bool) {
+	if !isLocalURL(imp.Spec) || strings.Contains(imp.Spec, "?") || !hasExt(imp.Spec, ".css") {
+		return "", false
+	}
+	if strings.HasSuffix(imp.Spec, ".module.css") {
+		return strings.TrimSuffix(imp.Spec, ".css") + ".js", true
+	}
+	if cssTypeExpr.MatchString(imp.Attrs) {
+		return withQuery(imp.Spec, "sheet"), true
+	}
+	return withQuery(imp.Spec, "module"), true
+}
+
+// resolveSpec resolves a local specifier relative to the http path of
+// the file it appears in, keeping its query, or returns an empty string.
+func resolveSpec(fromPath, spec string) string {
+	if !isLocalURL(spec) || !(strings.HasPrefix(spec, "/") || strings.HasPrefix(spec, "./") || strings.HasPrefix(spec, "../")) {
+		return ""
+	}
+	parts := strings.SplitN(spec, "?", 2)
+	abs := resolveURL(fromPath, parts[0])
+	if len(parts) > 1 {
+		abs = withQuery(abs, parts[1])
+	}
+	return abs
+}
+
+func hasExt(spec, ext string) bool {
+	return path.Ext(strings.SplitN(spec, "?", 2)[0]) == ext
+}
+
+func withQuery(spec, query string) string {
+	if strings.Contains(spec, "?") {
+		return spec + "&" + query
+	}
+	return spec + "?" + query
+}
+
+var identExpr = regexp.MustCompile(`^[A-Za-z_$][\w$]*$`)
+
+var reservedWords = []string{
+	"await", "break", "case", "catch", "class", "const", "continue", "debugger",
+	"default", "delete", "do", "else", "enum", "export", "extends", "false",
+	"finally", "for", "function", "if", "implements", "import", "in",
+	"instanceof", "interface", "let", "new", "null", "package", "private",
+	"protected", "public", "return", "static", "super", "switch", "this",
+	"throw", "true", "try", "typeof", "var", "void", "while", "with", "yield",
+}
+
+// isExportableName reports whether name can be declared as a named export.
+func isExportableName(name string) bool {
+	return identExpr.MatchString(name) && !contains(reservedWords, name)
+}
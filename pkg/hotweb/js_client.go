@@ -4,81 +4,671 @@ var ClientFilename = "client.mjs"
 var ClientSourceTmpl = `
 let listeners = {};
 let refreshers = [];
-let ws = undefined;
+let send = undefined;
 let debug = {{if .Debug}}true{{else}}false{{end}};
+let fallback = "{{.Fallback}}";
+let reloadOnError = {{if .ReloadOnError}}true{{else}}false{{end}};
+let failed = {};
+let retries = {};
+let versions = {};
 
  
 (function connect() {
-    ws = new WebSocket("{{.Endpoint}}");
-    if (debug) {
-        ws.onopen = () => console.debug("hotweb websocket open");
-        ws.onclose = () => console.debug("hotweb websocket closed");
-    }
+    let scheme = location.protocol == "https:" ? "wss://" : "ws://";
+    let ws = new WebSocket(scheme+"{{.Endpoint}}");
+    let opened = false;
+    ws.onopen = () => {
+        if (debug) {
+            console.debug("hotweb websocket open");
+        }
+        opened = true;
+        send = (msg) => ws.send(JSON.stringify(msg));
+        sendSubscriptions();
+    };
+    ws.onclose = () => {
+        if (debug) {
+            console.debug("hotweb websocket closed");
+        }
+        send = undefined;
+        // proxies that break websocket upgrades fail the connection
+        // before it opens, so fall back to server-sent events
+        if (!opened) {
+            connectEvents();
+        }
+    };
     ws.onerror = (err) => console.debug("hotweb websocket error: ", err);
-    ws.onmessage = async (event) => {
-        let msg = JSON.parse(event.data);
+    ws.onmessage = (event) => receive(JSON.parse(event.data));
+})();  
+
+// connectEvents receives updates from a server-sent event stream and
+// posts messages back with the id the stream starts with.
+function connectEvents() {
+    let url = location.protocol+"//{{.Endpoint}}/{{.EventsFilename}}";
+    let events = new EventSource(url);
+    events.addEventListener("hello", (event) => {
         if (debug) {
-            console.debug("hotweb trigger:", msg.path);
-        }
-        let paths = Object.keys(listeners);
-        paths.sort((a, b) => b.length - a.length);
-        for (const idx in paths) {
-            let path = paths[idx];
-            if (msg.path.startsWith(path)) {
-                for (const i in listeners[path]) {
-                    await listeners[path][i]((new Date()).getTime(), msg.path);
+            console.debug("hotweb event stream open");
+        }
+        let id = JSON.parse(event.data).id;
+        send = (msg) => fetch(url+"?id="+encodeURIComponent(id), {
+            method: "POST",
+            headers: {"Content-Type": "application/json"},
+            body: JSON.stringify(msg),
+        }).catch((err) => console.debug("hotweb event stream error: ", err));
+        // a reconnected stream is a new client on the server
+        unsent = Array.from(subscriptions);
+        sendSubscriptions();
+    });
+    events.onerror = (err) => console.debug("hotweb event stream error: ", err);
+    events.onmessage = (event) => receive(JSON.parse(event.data));
+}
+
+function receive(update) {
+    if (debug) {
+        console.debug("hotweb update:", update.changes.map((msg) => msg.path));
+    }
+    // updates are applied one at a time in the order they arrive
+    applying = applying.then(() => apply(update));
+}
+
+let applying = Promise.resolve();
+let updateErrors = {};
+
+// apply calls the listeners for each change in an update, then the
+// refreshers once all of them are done, and acknowledges the update
+// with any errors and how long it took.
+async function apply(update) {
+    let start = performance.now();
+    let changed = [];
+    updateErrors = {};
+    for (const msg of update.changes) {
+        // skip saves that didn't change the contents of a file
+        if (msg.version && versions[msg.path] == msg.version) {
+            continue;
+        }
+        versions[msg.path] = msg.version;
+        changed.push(msg.path);
+        await dispatch(msg);
+    }
+    if (changed.length > 0) {
+        // retry modules that failed to reload since their
+        // dependencies may have been fixed by this change
+        for (const path of Object.keys(failed)) {
+            if (!changed.includes(path)) {
+                // failed imports are cached by url, so retries add a
+                // count to the content version
+                retries[path] = (retries[path] || 0) + 1;
+                let version = (versions[path] || String((new Date()).getTime()))+"-"+retries[path];
+                await dispatch({path: path, version: version, retry: true});
+            }
+        }
+        for (const cb of refreshers) {
+            try {
+                await cb();
+            } catch (err) {
+                console.error("hotweb: refresh failed:", err);
+                updateErrors[""] = errorString(err);
+            }
+        }
+    }
+    report({
+        type: "ack",
+        id: update.id,
+        paths: changed,
+        errors: updateErrors,
+        duration: performance.now() - start,
+    });
+    if (reloadOnError && Object.keys(updateErrors).length > 0) {
+        location.reload();
+    }
+}
+
+// dispatch calls the listeners for a changed path with the version of
+// its content, or a timestamp if the server didn't send one.
+async function dispatch(msg) {
+    let version = msg.version || String((new Date()).getTime());
+    let paths = Object.keys(listeners);
+    paths.sort((a, b) => b.length - a.length);
+    for (const idx in paths) {
+        let path = paths[idx];
+        if (msg.path.startsWith(path)) {
+            for (const i in listeners[path]) {
+                try {
+                    await listeners[path][i](version, msg.path, msg);
+                } catch (err) {
+                    console.error("hotweb: update of "+msg.path+" failed:", err);
+                    updateErrors[msg.path] = errorString(err);
                 }
             }
         }
-        // wtf why aren't refreshers consistently 
-        // run after listeners are called.
-        // setTimeout workaround seems ok for now
-        setTimeout(() => refreshers.forEach((cb) => cb()), 20);
-    }; 
-})();  
+    }
+}
+
+function report(msg) {
+    if (send) {
+        send(msg);
+    }
+}
+
+function errorString(err) {
+    return String((err && err.message) || err);
+}
 
-export function accept(path, cb) {
+// tryImport imports url, a new version of the module at path, or its
+// source if it was pushed with the change. If it fails the error is added
+// to the update's acknowledgement and undefined returned so the previous
+// module is kept, and the import is retried on the next change or the
+// page reloaded once the update is acknowledged.
+export async function tryImport(path, url, source) {
+    let blob = source ? URL.createObjectURL(new Blob([source], {type: "text/javascript"})) : undefined;
+    try {
+        let mod;
+        try {
+            mod = await import(blob || url);
+        } catch (err) {
+            // Blob URLs may not be allowed by the content security policy
+            if (!blob) {
+                throw err;
+            }
+            mod = await import(url);
+        }
+        delete failed[path];
+        return mod;
+    } catch (err) {
+        console.error("hotweb: failed to reload "+path+", keeping previous module:", err);
+        updateErrors[path] = errorString(err);
+        if (!reloadOnError) {
+            failed[path] = true;
+        }
+        return undefined;
+    } finally {
+        if (blob) {
+            URL.revokeObjectURL(blob);
+        }
+    }
+}
+
+// accept calls cb with changes to paths starting with path. The version
+// of the file at path that is already loaded can be given so saving it
+// without changes doesn't update it.
+export function accept(path, cb, version) {
+    if (version && versions[path] === undefined) {
+        versions[path] = version;
+    }
+    subscribe(path+"*");
+    listen(path, cb);
+}
+
+function listen(path, cb) {
     if (listeners[path] === undefined) {
         listeners[path] = [];
     }
     listeners[path].push(cb);
 }
 
+let subscriptions = new Set();
+let unsent = [];
+
+// subscribe asks the server to send changes to path, or paths starting
+// with path if it ends with *. Changes to other paths aren't sent.
+function subscribe(path) {
+    if (!path || subscriptions.has(path)) {
+        return;
+    }
+    subscriptions.add(path);
+    unsent.push(path);
+    if (unsent.length == 1) {
+        Promise.resolve().then(sendSubscriptions);
+    }
+}
+
+function sendSubscriptions() {
+    if (unsent.length == 0 || !send) {
+        return;
+    }
+    report({type: "subscribe", paths: unsent, origin: new URL(import.meta.url).origin});
+    unsent = [];
+}
+
+// subscribePage subscribes to the paths collect finds in the page, now
+// and whenever the document changes.
+function subscribePage(collect) {
+    let scan = () => collect().forEach(subscribe);
+    let scheduled = false;
+    new MutationObserver(() => {
+        if (!scheduled) {
+            scheduled = true;
+            requestAnimationFrame(() => {
+                scheduled = false;
+                scan();
+            });
+        }
+    }).observe(document, {
+        childList: true,
+        subtree: true,
+        attributes: true,
+        attributeFilter: ["src", "srcset", "href", "style", "poster"],
+    });
+    scan();
+}
+
 export function refresh(cb) {
     refreshers.push(cb);
     cb();
 }
 
-export function watchHTML() {
+// watchHTML reloads the page when its document changes. With morph set
+// the new document is fetched and morphed into the current one to keep
+// application state, reloading only if its scripts changed.
+export function watchHTML(opts = {}) {
     let withIndex = "";
     if (location.pathname[location.pathname.length-1] == "/") {
         withIndex = location.pathname + "index.html";
     } else {
         withIndex = location.pathname + "/index.html";
     }
-    accept(location.pathname, (ts, path) => {
-        if (path == location.pathname || path == withIndex) {
+    let scripts = scriptsKey(document);
+    let reload = async (ts, path) => {
+        if (path != location.pathname && path != withIndex && path != fallback) {
+            return;
+        }
+        if (!opts.morph) {
+            location.reload();
+            return;
+        }
+        let resp = await fetch(location.href.split("#")[0], {cache: "no-store", headers: {"Accept": "text/html"}});
+        let doc = new DOMParser().parseFromString(await resp.text(), "text/html");
+        if (!resp.ok || scriptsKey(doc) != scripts) {
             location.reload();
+            return;
+        }
+        morphAttributes(document.documentElement, doc.documentElement);
+        morphNode(document.head, doc.head);
+        morphNode(document.body, doc.body);
+    };
+    subscribe(location.pathname);
+    subscribe(withIndex);
+    subscribe(fallback);
+    // client-side routes may be served the fallback document
+    // which is not under the current location
+    listen(fallback ? "" : location.pathname, reload);
+}
+
+// scriptsKey identifies the scripts in a document so a morph can tell
+// when scripts changed and need a full reload to run.
+function scriptsKey(doc) {
+    return Array.from(doc.querySelectorAll("script")).map((script) => {
+        return [script.type, script.getAttribute("src"), script.hasAttribute("src") ? "" : script.textContent].join("|");
+    }).join("\n");
+}
+
+// preserved nodes are left alone by morphs: styles injected by hotweb,
+// scripts that already ran, and elements marked data-hotweb-preserve.
+function preserved(node) {
+    return node.nodeType == Node.ELEMENT_NODE &&
+        (node.nodeName == "SCRIPT" || node.hasAttribute("data-hotweb-path") || node.hasAttribute("data-hotweb-preserve"));
+}
+
+function morphNode(from, to) {
+    if (from.nodeType != Node.ELEMENT_NODE) {
+        if (from.nodeValue != to.nodeValue) {
+            from.nodeValue = to.nodeValue;
+        }
+        return;
+    }
+    if (preserved(from)) {
+        return;
+    }
+    morphAttributes(from, to);
+    // an element empty in the document but not on the page is assumed
+    // to be the root a framework mounted into, like a body it renders into
+    if (blank(to) && !blank(from) && from != document.head) {
+        return;
+    }
+    if (from == document.activeElement && from.nodeName == "TEXTAREA") {
+        return;
+    }
+    morphChildren(from, to);
+}
+
+// blank reports whether an element has no children other than
+// whitespace, like the line break left in an empty body.
+function blank(el) {
+    return Array.from(el.childNodes).every((child) => child.nodeType == Node.TEXT_NODE && child.nodeValue.trim() == "");
+}
+
+function morphChildren(from, to) {
+    let keyed = {};
+    from.childNodes.forEach((child) => {
+        if (child.id) {
+            keyed[child.id] = child;
         }
     });
+    let skip = (node) => {
+        while (node && preserved(node) && node.nodeName != "SCRIPT") {
+            node = node.nextSibling;
+        }
+        return node;
+    };
+    let pos = skip(from.firstChild);
+    for (const child of Array.from(to.childNodes)) {
+        let match = child.id ? keyed[child.id] : undefined;
+        if (match === undefined && pos && !pos.id && pos.nodeType == child.nodeType && pos.nodeName == child.nodeName) {
+            match = pos;
+        }
+        if (match === undefined) {
+            from.insertBefore(document.importNode(child, true), pos);
+            continue;
+        }
+        if (match === pos) {
+            pos = skip(pos.nextSibling);
+        } else {
+            from.insertBefore(match, pos);
+        }
+        morphNode(match, child);
+    }
+    while (pos) {
+        let next = skip(pos.nextSibling);
+        pos.remove();
+        pos = next;
+    }
+}
+
+function morphAttributes(from, to) {
+    // keep what the user is typing into a focused input
+    let focused = from == document.activeElement;
+    for (const attr of Array.from(from.attributes)) {
+        if (!to.hasAttribute(attr.name) && !(focused && attr.name == "value")) {
+            from.removeAttribute(attr.name);
+        }
+    }
+    for (const attr of Array.from(to.attributes)) {
+        if (focused && attr.name == "value") {
+            continue;
+        }
+        // stylesheets swapped by watchCSS have a version added to their href
+        if (from.nodeName == "LINK" && attr.name == "href" && urlPath(from.getAttribute("href")) == urlPath(attr.value)) {
+            continue;
+        }
+        if (from.getAttribute(attr.name) !== attr.value) {
+            from.setAttribute(attr.name, attr.value);
+        }
+    }
 }
 
 export function watchCSS() {
-    accept("", (ts, path) => {
-        if (path.endsWith(".css")) {
-            let link = document.createElement('link');
-            link.setAttribute('rel', 'stylesheet');
-            link.setAttribute('type', 'text/css');
-            link.setAttribute('href', path+'?'+(new Date()).getTime());
-            document.getElementsByTagName('head')[0].appendChild(link);
-            let styles = document.getElementsByTagName("link");
-            for (let i=0; i<styles.length; i++) {
-                if (i < styles.length-1 && styles[i].getAttribute("href").startsWith(path)) {
-                    styles[i].remove();
+    subscribePage(stylePaths);
+    listen("", (ts, path, msg) => {
+        if (!path.endsWith(".css")) {
+            return;
+        }
+        // styles includes stylesheets that @import the changed path
+        let styles = msg.styles || [path];
+        for (const root of styleRoots()) {
+            root.querySelectorAll('link[rel="stylesheet"]').forEach((link) => {
+                if (styles.includes(urlPath(link.getAttribute("href")))) {
+                    swapLink(link, ts);
+                }
+            });
+            root.querySelectorAll("style").forEach((style) => {
+                if (styleImports(style.textContent).some((spec) => styles.includes(urlPath(spec)))) {
+                    swapStyle(style, styles, ts);
+                }
+            });
+            (root.adoptedStyleSheets || []).forEach((sheet) => {
+                let path = adoptedSheets.get(sheet);
+                if (path && styles.includes(path)) {
+                    replaceSheet(sheet, path, ts);
+                }
+            });
+        }
+    });
+}
+
+// watchAssets cache-busts images, media and fonts referenced by the page
+// when they change, in src and srcset attributes, inline styles and the
+// url() references of stylesheet rules like background-image and @font-face.
+export function watchAssets() {
+    subscribePage(assetPaths);
+    listen("", (ts, path) => {
+        for (const root of styleRoots()) {
+            root.querySelectorAll("img[src], source[src], video[src], audio[src], track[src], input[src], embed[src]").forEach((el) => {
+                if (urlPath(el.getAttribute("src")) == path) {
+                    el.setAttribute("src", versioned(el.getAttribute("src"), ts));
+                    if (el.nodeName == "SOURCE" && el.parentNode.load) {
+                        el.parentNode.load();
+                    }
+                }
+            });
+            root.querySelectorAll("video[poster]").forEach((el) => {
+                if (urlPath(el.getAttribute("poster")) == path) {
+                    el.setAttribute("poster", versioned(el.getAttribute("poster"), ts));
                 }
+            });
+            root.querySelectorAll("img[srcset], source[srcset]").forEach((el) => {
+                let srcset = el.getAttribute("srcset").split(",").map((candidate) => {
+                    let [url, ...descriptors] = candidate.trim().split(/\s+/);
+                    if (urlPath(url) != path) {
+                        return candidate;
+                    }
+                    return [versioned(url, ts), ...descriptors].join(" ");
+                }).join(", ");
+                if (srcset != el.getAttribute("srcset")) {
+                    el.setAttribute("srcset", srcset);
+                }
+            });
+            root.querySelectorAll('[style*="url("]').forEach((el) => {
+                let style = cssURLs(el.getAttribute("style"), document.baseURI, path, ts);
+                if (style != el.getAttribute("style")) {
+                    el.setAttribute("style", style);
+                }
+            });
+            let sheets = Array.from(root.styleSheets || []).concat(root.adoptedStyleSheets || []);
+            sheets.forEach((sheet) => bustRules(sheet, sheet.href || document.baseURI, path, ts));
+        }
+    });
+}
+
+// stylePaths returns the paths of stylesheets loaded by the page.
+// Changes to sheets they @import are sent for them too.
+function stylePaths() {
+    let paths = [];
+    for (const root of styleRoots()) {
+        root.querySelectorAll('link[rel="stylesheet"]').forEach((link) => {
+            paths.push(urlPath(link.getAttribute("href")));
+        });
+        root.querySelectorAll("style").forEach((style) => {
+            styleImports(style.textContent).forEach((spec) => paths.push(urlPath(spec)));
+        });
+    }
+    return paths;
+}
+
+// assetPaths returns the paths of the files watchAssets can update.
+function assetPaths() {
+    let paths = [];
+    for (const root of styleRoots()) {
+        root.querySelectorAll("img[src], source[src], video[src], audio[src], track[src], input[src], embed[src]").forEach((el) => {
+            paths.push(urlPath(el.getAttribute("src")));
+        });
+        root.querySelectorAll("video[poster]").forEach((el) => {
+            paths.push(urlPath(el.getAttribute("poster")));
+        });
+        root.querySelectorAll("img[srcset], source[srcset]").forEach((el) => {
+            el.getAttribute("srcset").split(",").forEach((candidate) => {
+                paths.push(urlPath(candidate.trim().split(/\s+/)[0]));
+            });
+        });
+        let collect = (css, base) => mapCSSURLs(css, base, (ref, spec) => {
+            paths.push(urlPath(spec, base));
+            return ref;
+        });
+        root.querySelectorAll('[style*="url("]').forEach((el) => collect(el.getAttribute("style"), document.baseURI));
+        let sheets = Array.from(root.styleSheets || []).concat(root.adoptedStyleSheets || []);
+        sheets.forEach((sheet) => eachRuleStyle(sheet, sheet.href || document.baseURI, (style, base) => collect(style.cssText, base)));
+    }
+    return paths;
+}
+
+// eachRuleStyle calls fn with the style of each rule in a stylesheet,
+// including nested and imported rules, that has url() references.
+function eachRuleStyle(sheet, base, fn) {
+    let rules;
+    try {
+        rules = sheet.cssRules;
+    } catch (e) {
+        // rules of cross-origin stylesheets are not readable
+        return;
+    }
+    for (const rule of Array.from(rules)) {
+        if (rule.cssRules) {
+            eachRuleStyle(rule, base, fn);
+        }
+        if (rule.styleSheet) {
+            eachRuleStyle(rule.styleSheet, rule.styleSheet.href || base, fn);
+        }
+        if (rule.style && rule.style.cssText.includes("url(")) {
+            fn(rule.style, base);
+        }
+    }
+}
+
+function bustRules(sheet, base, path, ts) {
+    eachRuleStyle(sheet, base, (style, base) => {
+        for (const prop of Array.from(style)) {
+            let value = style.getPropertyValue(prop);
+            let busted = cssURLs(value, base, path, ts);
+            if (busted != value) {
+                style.setProperty(prop, busted, style.getPropertyPriority(prop));
             }
         }
     });
 }
 
+// cssURLs versions the url() references in css that resolve to path.
+function cssURLs(css, base, path, ts) {
+    return mapCSSURLs(css, base, (ref, spec) => {
+        return urlPath(spec, base) == path ? 'url("'+versioned(spec, ts)+'")' : ref;
+    });
+}
+
+function mapCSSURLs(css, base, fn) {
+    return css.replace(/url\(\s*(["']?)([^"')]+)\1\s*\)/g, (ref, quote, spec) => fn(ref, spec));
+}
+
+function versioned(href, ts) {
+    return href.split("?")[0]+"?v="+ts;
+}
+
+// registerSheet associates a constructed stylesheet with the path of its
+// source so watchCSS can replace its rules when the source changes.
+export function registerSheet(path, sheet) {
+    subscribe(path);
+    adoptedSheets.set(sheet, path);
+}
+
+let adoptedSheets = new WeakMap();
+
+let injectedStyles = {};
+let constructedSheets = {};
+
+// injectStyle adds or updates a style element for a stylesheet imported
+// by a module. If given, reimport is imported to hot swap the styles when
+// the stylesheet changes.
+export function injectStyle(path, css, reimport) {
+    let style = injectedStyles[path];
+    if (style === undefined) {
+        style = document.createElement("style");
+        style.setAttribute("data-hotweb-path", path);
+        document.head.appendChild(style);
+        injectedStyles[path] = style;
+        acceptStyle(path, reimport);
+    }
+    style.textContent = css;
+    return css;
+}
+
+// styleSheet returns a constructed stylesheet for a CSS module script
+// import, replacing its rules when reimport is imported.
+export function styleSheet(path, css, reimport) {
+    let sheet = constructedSheets[path];
+    if (sheet === undefined) {
+        sheet = new CSSStyleSheet();
+        constructedSheets[path] = sheet;
+        acceptStyle(path, reimport);
+    }
+    sheet.replaceSync(css);
+    return sheet;
+}
+
+function acceptStyle(path, reimport) {
+    if (!reimport) {
+        return;
+    }
+    subscribe(path);
+    listen("", (ts, changed, msg) => {
+        if ((msg.styles || [changed]).includes(path)) {
+            return tryImport(path, reimport+"&v="+ts);
+        }
+    });
+}
+
+function styleRoots(root = document, roots = [document]) {
+    root.querySelectorAll("*").forEach((el) => {
+        if (el.shadowRoot) {
+            roots.push(el.shadowRoot);
+            styleRoots(el.shadowRoot, roots);
+        }
+    });
+    return roots;
+}
+
+function urlPath(href, base = document.baseURI) {
+    let url = new URL(href, base);
+    if (url.host != location.host) {
+        return undefined;
+    }
+    return url.pathname;
+}
+
+function styleImports(css) {
+    let specs = [];
+    let re = /@import\s+(?:url\(\s*)?["']?([^"'()\s;]+)["']?\s*\)?/g;
+    let match;
+    while ((match = re.exec(css)) !== null) {
+        specs.push(match[1]);
+    }
+    return specs;
+}
+
+// swapLink loads a fresh copy of a stylesheet next to the old one and
+// only removes the old one once loaded to avoid a flash of unstyled content.
+function swapLink(link, ts) {
+    let fresh = link.cloneNode();
+    fresh.setAttribute("href", link.getAttribute("href").split("?")[0]+"?v="+ts);
+    fresh.onload = () => link.remove();
+    fresh.onerror = () => fresh.remove();
+    link.after(fresh);
+}
+
+function swapStyle(style, styles, ts) {
+    let fresh = style.cloneNode();
+    fresh.textContent = style.textContent.replace(/(@import\s+(?:url\(\s*)?["']?)([^"'()\s;]+)/g, (rule, prefix, spec) => {
+        if (!styles.includes(urlPath(spec))) {
+            return rule;
+        }
+        return prefix+spec.split("?")[0]+"?v="+ts;
+    });
+    fresh.onload = () => style.remove();
+    fresh.onerror = () => fresh.remove();
+    style.after(fresh);
+}
+
+async function replaceSheet(sheet, path, ts) {
+    let resp = await fetch(path+"?v="+ts);
+    if (resp.ok) {
+        await sheet.replace(await resp.text());
+    }
+}
+
 `
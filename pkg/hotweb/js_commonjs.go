@@ -0,0 +1,21 @@
+package hotweb
+
+var CommonJSModuleTmpl = `{{range .Requires}}import * as {{.Name}} from '{{.Path}}';
+{{end}}
+const __hotweb_module = { exports: {} };
+const __hotweb_requires = {
+{{range .Requires}}	{{.Spec}}: {{if .CommonJS}}{{.Name}}.default{{else}}{{.Name}}{{end}},
+{{end}}};
+
+(function (module, exports, require) {
+{{.Source}}
+}).call(__hotweb_module.exports, __hotweb_module, __hotweb_module.exports, (spec) => {
+	if (!(spec in __hotweb_requires)) {
+		throw new Error("hotweb: cannot require " + spec);
+	}
+	return __hotweb_requires[spec];
+});
+
+export default __hotweb_module.exports.__esModule ? __hotweb_module.exports.default : __hotweb_module.exports;
+{{range .Exports}}export const {{.}} = __hotweb_module.exports.{{.}};
+{{end}}`
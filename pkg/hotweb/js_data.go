@@ -0,0 +1,7 @@
+package hotweb
+
+var DataModuleTmpl = `const __data = {{.Data}};
+
+export default __data;
+{{range .Exports}}export const {{.}} = __data.{{.}};
+{{end}}`
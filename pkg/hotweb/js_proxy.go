@@ -1,18 +1,26 @@
 package hotweb
 
 var ModuleProxyTmpl = `import * as hotweb from '{{.ClientPath}}';
-import * as mod from '{{.Path}}?0';
+import * as mod from '{{.Path}}?v={{.Version}}';
 
 {{range .Exports}}let {{.}}Proxy = mod.{{.}};
 {{end}}
+let version = '{{.Version}}';
 
-hotweb.accept('{{.Path}}', async (ts) => {
+hotweb.accept('{{.Path}}', async (ts, path, msg) => {
+	if (path != '{{.Path}}' || ts == version) {
+		return;
+	}
 {{ if .Reload }}	location.reload();
-{{ else }}	let newMod = await import("{{.Path}}?"+ts);
+{{ else }}	let newMod = await hotweb.tryImport('{{.Path}}', "{{.Path}}?v="+ts, msg.source);
+	if (newMod === undefined) {
+		return;
+	}
+	version = ts;
 {{range .Exports}}	{{.}}Proxy = newMod.{{.}};
 {{end}}
 {{- end -}}
-});
+}, version);
 
 export {
 {{range .Exports}}	{{.}}Proxy as {{.}},
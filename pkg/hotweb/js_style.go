@@ -0,0 +1,18 @@
+package hotweb
+
+var StyleModuleTmpl = `import * as hotweb from '{{.ClientPath}}';
+
+export default hotweb.injectStyle({{.Path}}, {{.CSS}}, {{.Reimport}});
+`
+
+var StyleSheetModuleTmpl = `import * as hotweb from '{{.ClientPath}}';
+
+export default hotweb.styleSheet({{.Path}}, {{.CSS}}, {{.Reimport}});
+`
+
+var CSSModuleTmpl = `import * as hotweb from '{{.ClientPath}}';
+
+hotweb.injectStyle({{.Path}}, {{.CSS}});
+
+export default {{.Names}};
+`
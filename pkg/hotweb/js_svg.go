@@ -0,0 +1,9 @@
+package hotweb
+
+var SVGModuleTmpl = `{{if .Mithril}}export default {
+	view: ({attrs: props}) => {{.Element}},
+};
+{{else}}export default function (props) {
+	return {{.Element}};
+}
+{{end}}`
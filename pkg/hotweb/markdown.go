@@ -0,0 +1,117 @@
+package hotweb
+
+import (
+	"bytes"
+	"html/template"
+	"path"
+	"strings"
+
+	"github.com/progrium/hotweb/pkg/markdown"
+	"github.com/spf13/afero"
+)
+
+// LayoutFilename is the layout used for Markdown pages in its directory
+// and below that don't set a layout in their front matter.
+var LayoutFilename = "_layout.html"
+
+var ErrorPageTmpl = `<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <title>{{.File}}</title>
+    <script type="module">
+      import * as hotweb from '{{.ClientPath}}';
+      hotweb.watchHTML();
+    </script>
+  </head>
+  <body>
+    <pre>hotweb: {{.File}}: {{.Error}}</pre>
+  </body>
+</html>
+`
+
+var errorPageTmpl = template.Must(template.New("error").Parse(ErrorPageTmpl))
+
+var DefaultLayoutTmpl = `<!DOCTYPE html>
+<html>
+  <head>
+    <meta charset="utf-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1">
+    <title>{{.Title}}</title>
+    <script type="module">
+      import * as hotweb from '{{.ClientPath}}';
+      hotweb.watchHTML();
+    </script>
+  </head>
+  <body>
+{{.Content}}
+  </body>
+</html>
+`
+
+// layoutFor returns the layout for a Markdown page, the layout named in
+// its front matter or the nearest LayoutFilename up to its mount root.
+// Layouts looked for are recorded as dependencies of dst, so adding a
+// nearer layout rebuilds the page.
+func (m *Handler) layoutFor(fs afero.Fs, dst, src string, page markdown.Page) (string, bool) {
+	if layout, ok := page.Meta["layout"].(string); ok && layout != "" {
+		layout = path.Join(path.Dir(src), layout)
+		m.Fs.Depend(dst, layout)
+		return layout, true
+	}
+	root := m.mountForFsPath(src).Dir
+	for dir := path.Dir(src); strings.HasPrefix(dir, root); dir = path.Dir(dir) {
+		layout := path.Join(dir, LayoutFilename)
+		m.Fs.Depend(dst, layout)
+		if ok, _ := afero.Exists(fs, layout); ok {
+			return layout, true
+		}
+		if dir == root || dir == "/" || dir == "." {
+			break
+		}
+	}
+	return "", false
+}
+
+// buildMarkdownPage renders a Markdown file into its layout read from fs.
+func (m *Handler) buildMarkdownPage(fs afero.Fs, dst, src string, b []byte) ([]byte, error) {
+	page, err := markdown.Parse(b)
+	if err != nil {
+		return m.errorPage(src, err), nil
+	}
+	layout, layoutSrc := src, []byte(DefaultLayoutTmpl)
+	if found, ok := m.layoutFor(fs, dst, src, page); ok {
+		layout = found
+		layoutSrc, err = afero.ReadFile(fs, layout)
+		if err != nil {
+			return m.errorPage(src, err), nil
+		}
+	}
+	tmpl, err := m.parseTemplate(fs, dst, layout, layoutSrc, 0)
+	if err != nil {
+		return m.errorPage(src, err), nil
+	}
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, map[string]interface{}{
+		"Title":      page.Title(strings.TrimSuffix(path.Base(src), path.Ext(src))),
+		"Content":    page.Content,
+		"Page":       page.Meta,
+		"ClientPath": path.Join(m.Prefix, InternalPath, ClientFilename),
+	})
+	if err != nil {
+		return m.errorPage(src, err), nil
+	}
+	return buf.Bytes(), nil
+}
+
+// errorPage is served in place of a page that failed to build so the
+// error shows up in the browser. It reloads once the page is fixed.
+func (m *Handler) errorPage(src string, err error) []byte {
+	var buf bytes.Buffer
+	errorPageTmpl.Execute(&buf, map[string]interface{}{
+		"File":       path.Base(src),
+		"Error":      err.Error(),
+		"ClientPath": path.Join(m.Prefix, InternalPath, ClientFilename),
+	})
+	return buf.Bytes()
+}
@@ -0,0 +1,145 @@
+package hotweb
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// MaxModulePreloads limits the modules preloaded for a page, zero
+// disables preloading.
+var MaxModulePreloads = 100
+
+var scriptExpr = regexp.MustCompile(`(?is)<script\b([^>]*)>(.*?)</script>`)
+
+var moduleTypeExpr = regexp.MustCompile(`(?i)\btype\s*=\s*["']?module\b`)
+
+var srcAttrExpr = regexp.MustCompile(`(?i)\bsrc\s*=\s*["']?([^"'\s>]+)`)
+
+// staticImports returns the specifiers of the static imports in src.
+func staticImports(src []byte) []string {
+	var specs []string
+	rewriteImports(src, func(imp moduleImport) (string, bool) {
+		specs = append(specs, imp.Spec)
+		return "", false
+	})
+	return specs
+}
+
+// resolveSrc resolves the src of a script, a relative URL rather than a
+// module specifier, or returns an empty string if it isn't local.
+func resolveSrc(urlPath, src string) string {
+	if !isLocalURL(src) {
+		return ""
+	}
+	if !strings.HasPrefix(src, "/") && !strings.HasPrefix(src, "./") && !strings.HasPrefix(src, "../") {
+		src = "./" + src
+	}
+	return resolveSpec(urlPath, src)
+}
+
+// modulePreloads returns the urls of the modules the module scripts in
+// the page at urlPath import, directly or through other modules, so they
+// can be preloaded instead of discovered one import at a time. Modules
+// are served as a proxy importing a versioned source, so both are listed.
+func (m *Handler) modulePreloads(urlPath string, page []byte) []string {
+	var queue []string
+	for _, script := range scriptExpr.FindAllSubmatch(page, -1) {
+		if !moduleTypeExpr.Match(script[1]) {
+			continue
+		}
+		if src := srcAttrExpr.FindSubmatch(script[1]); src != nil {
+			queue = append(queue, resolveSrc(urlPath, string(src[1])))
+			continue
+		}
+		for _, spec := range staticImports(script[2]) {
+			queue = append(queue, resolveSpec(urlPath, spec))
+		}
+	}
+
+	var preloads []string
+	seen := make(map[string]bool)
+	add := func(u string) {
+		if !seen[u] && len(preloads) < MaxModulePreloads {
+			seen[u] = true
+			preloads = append(preloads, u)
+		}
+	}
+	for len(queue) > 0 && len(preloads) < MaxModulePreloads {
+		u := queue[0]
+		queue = queue[1:]
+		if u == "" || seen[u] {
+			continue
+		}
+		parts := strings.SplitN(u, "?", 2)
+		fsPath, ok := m.fsPath(parts[0])
+		if !ok {
+			continue
+		}
+		if exists, _ := afero.Exists(m.Fs, fsPath); !exists {
+			continue
+		}
+		r := &http.Request{URL: &url.URL{Path: parts[0]}}
+		switch {
+		case m.isValidJS(r):
+			add(u)
+			if len(parts) == 1 {
+				add(path.Join(m.Prefix, InternalPath, ClientFilename))
+				add(parts[0] + "?v=" + m.version(fsPath))
+			}
+			src, err := m.readModule(fsPath)
+			if err != nil {
+				continue
+			}
+			for _, spec := range staticImports(rewriteModuleImports(src)) {
+				queue = append(queue, resolveSpec(parts[0], spec))
+			}
+		case path.Ext(parts[0]) == ".css" && len(parts) > 1:
+			// stylesheets imported from modules
+			add(u)
+			add(path.Join(m.Prefix, InternalPath, ClientFilename))
+		}
+	}
+	return preloads
+}
+
+// addModulePreloads adds a Link header preloading the modules of a page.
+func (m *Handler) addModulePreloads(w http.ResponseWriter, urlPath string, page []byte) {
+	if MaxModulePreloads <= 0 {
+		return
+	}
+	var links []string
+	for _, u := range m.modulePreloads(urlPath, page) {
+		links = append(links, "<"+u+">; rel=modulepreload")
+	}
+	if len(links) > 0 {
+		w.Header().Set("Link", strings.Join(links, ", "))
+	}
+}
+
+// preloadFile adds module preloads for a HTML file served by the file
+// server, or the index.html of a directory.
+func (m *Handler) preloadFile(w http.ResponseWriter, r *http.Request) {
+	if MaxModulePreloads <= 0 || r.URL.RawQuery != "" {
+		return
+	}
+	fsPath, ok := m.fsPath(r.URL.Path)
+	if !ok {
+		return
+	}
+	if strings.HasSuffix(r.URL.Path, "/") {
+		fsPath = path.Join(fsPath, "index.html")
+	}
+	if !contains([]string{".html", ".htm"}, path.Ext(fsPath)) {
+		return
+	}
+	page, err := afero.ReadFile(m.Fs, fsPath)
+	if err != nil {
+		return
+	}
+	m.addModulePreloads(w, r.URL.Path, page)
+}
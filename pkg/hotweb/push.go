@@ -0,0 +1,54 @@
+package hotweb
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+)
+
+// originPlaceholder stands in for the origin of the server in pushed
+// module source until it is sent to a client, which tells us its origin.
+const originPlaceholder = "\x00hotweb-origin\x00"
+
+var dynamicImportExpr = regexp.MustCompile(`\bimport\s*\(\s*(["'])([^"'\n]+)["']\s*\)`)
+
+var importMetaURLExpr = regexp.MustCompile(`\bimport\.meta\.url\b`)
+
+// pushSource returns the source of a changed module to send to clients
+// with the change, if it is a module served with a proxy. Clients import
+// it from a Blob URL, which imports can't be resolved against, so local
+// imports are made absolute.
+func (m *Handler) pushSource(fsPath, urlPath string) (string, bool) {
+	if !m.isValidJS(&http.Request{URL: &url.URL{Path: urlPath}}) {
+		return "", false
+	}
+	src, err := m.readModule(fsPath)
+	if err != nil {
+		return "", false
+	}
+	src = rewriteModuleImports(src)
+	src = resolveImports(src, func(imp moduleImport) (string, bool) {
+		return absoluteSpec(urlPath, imp.Spec)
+	})
+	src = dynamicImportExpr.ReplaceAllFunc(src, func(expr []byte) []byte {
+		match := dynamicImportExpr.FindSubmatch(expr)
+		spec, ok := absoluteSpec(urlPath, string(match[2]))
+		if !ok {
+			return expr
+		}
+		quote := string(match[1])
+		return []byte("import(" + quote + spec + quote + ")")
+	})
+	src = importMetaURLExpr.ReplaceAll(src, []byte(`"`+originPlaceholder+urlPath+`"`))
+	return string(src), true
+}
+
+// absoluteSpec makes a local import specifier in the module at fromPath
+// an absolute url on the server at originPlaceholder.
+func absoluteSpec(fromPath, spec string) (string, bool) {
+	abs := resolveSpec(fromPath, spec)
+	if abs == "" {
+		return "", false
+	}
+	return originPlaceholder + abs, true
+}
@@ -0,0 +1,143 @@
+package hotweb
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"text/template"
+)
+
+var xmlNamespacePrefixes = map[string]string{
+	"http://www.w3.org/1999/xlink":         "xlink",
+	"http://www.w3.org/XML/1998/namespace": "xml",
+	"xmlns":                                "xmlns",
+}
+
+// svgElement converts SVG markup into calls to a JSX factory, merging
+// the props variable into the attributes of the root element. Attributes
+// are renamed to React props for React's createElement.
+func svgElement(factory string, src []byte) (string, error) {
+	react := strings.HasSuffix(factory, "createElement")
+	dec := xml.NewDecoder(bytes.NewReader(src))
+	var b strings.Builder
+	// number of children written to each open element
+	var open []int
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			if len(open) > 0 {
+				b.WriteString(", ")
+				open[len(open)-1]++
+			}
+			attrs := make(map[string]string)
+			for _, attr := range tok.Attr {
+				name := attr.Name.Local
+				if prefix, ok := xmlNamespacePrefixes[attr.Name.Space]; ok {
+					name = prefix + ":" + name
+				}
+				if attr.Name.Space == "" && name == "xmlns" {
+					continue
+				}
+				if react {
+					name = reactPropName(name)
+				}
+				attrs[name] = attr.Value
+			}
+			tag, _ := json.Marshal(tok.Name.Local)
+			jsonAttrs, _ := json.Marshal(attrs)
+			if len(open) == 0 {
+				fmt.Fprintf(&b, "%s(%s, Object.assign(%s, props)", factory, tag, jsonAttrs)
+			} else {
+				fmt.Fprintf(&b, "%s(%s, %s", factory, tag, jsonAttrs)
+			}
+			open = append(open, 0)
+		case xml.EndElement:
+			b.WriteString(")")
+			open = open[:len(open)-1]
+		case xml.CharData:
+			text := strings.TrimSpace(string(tok))
+			if len(open) == 0 || text == "" {
+				continue
+			}
+			jsonText, _ := json.Marshal(text)
+			b.WriteString(", ")
+			b.Write(jsonText)
+			open[len(open)-1]++
+		}
+	}
+	if b.Len() == 0 {
+		return "", fmt.Errorf("no svg element")
+	}
+	return b.String(), nil
+}
+
+// reactPropName returns the React prop for an SVG attribute, like
+// className for class and strokeWidth for stroke-width.
+func reactPropName(name string) string {
+	if name == "class" {
+		return "className"
+	}
+	if strings.HasPrefix(name, "data-") || strings.HasPrefix(name, "aria-") {
+		return name
+	}
+	parts := strings.FieldsFunc(name, func(r rune) bool {
+		return r == '-' || r == ':'
+	})
+	for i := 1; i < len(parts); i++ {
+		parts[i] = strings.ToUpper(parts[i][:1]) + parts[i][1:]
+	}
+	return strings.Join(parts, "")
+}
+
+// buildSVGModule makes a module default exporting a component rendering
+// an SVG file with factory, a Mithril component for the m factory and a
+// function component otherwise.
+func buildSVGModule(factory, filename string, src []byte) ([]byte, error) {
+	tmpl := template.Must(template.New("svg").Parse(SVGModuleTmpl))
+
+	element, err := svgElement(factory, src)
+	if err != nil {
+		msg, _ := json.Marshal(fmt.Sprintf("hotweb: %s: %v", path.Base(filename), err))
+		return []byte(fmt.Sprintf("throw new Error(%s);\n", msg)), nil
+	}
+
+	var b strings.Builder
+	err = tmpl.Execute(&b, map[string]interface{}{
+		"Mithril": factory == "m",
+		"Element": element,
+	})
+	return []byte(b.String()), err
+}
+
+// buildURLModule makes a module default exporting the http path of a
+// file with a version so a changed file isn't loaded from cache.
+func buildURLModule(urlPath string, src []byte) []byte {
+	url, _ := json.Marshal(urlPath + "?v=" + contentVersion(src))
+	return []byte(fmt.Sprintf("export default %s;\n", url))
+}
+
+// rewriteSVGImports points imports of SVG files at generated component
+// modules, or url modules for imports with a ?url query.
+func rewriteSVGImports(imp moduleImport) (string, bool) {
+	if !isLocalURL(imp.Spec) {
+		return "", false
+	}
+	if strings.HasSuffix(imp.Spec, ".svg?url") {
+		return strings.TrimSuffix(imp.Spec, "?url") + ".url.js", true
+	}
+	if strings.HasSuffix(imp.Spec, ".svg") {
+		return imp.Spec + ".js", true
+	}
+	return "", false
+}
@@ -0,0 +1,137 @@
+package hotweb
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/spf13/afero"
+)
+
+// maxIncludeDepth limits nested includes so include cycles fail to
+// render instead of recursing forever.
+const maxIncludeDepth = 16
+
+// isTemplatePage reports whether a request is for a HTML file that is
+// rendered as a template. Files made by transforms are already rendered.
+func (m *Handler) isTemplatePage(r *http.Request) bool {
+	if r.URL.RawQuery != "" {
+		return false
+	}
+	fsPath, ok := m.fsPath(r.URL.Path)
+	return ok && m.isTemplateFile(fsPath)
+}
+
+// isTemplateFile reports whether the file at fsPath is rendered as a
+// template when served.
+func (m *Handler) isTemplateFile(fsPath string) bool {
+	if !m.Templates || !contains([]string{".html", ".htm"}, path.Ext(fsPath)) || m.Fs.Made(fsPath) {
+		return false
+	}
+	fi, err := m.Fs.Stat(fsPath)
+	return err == nil && !fi.IsDir()
+}
+
+// parseTemplate parses the template src read from fsPath for the page
+// dst, reading included files from fs. Templates can include other files relative to their own path,
+// or to the mount root with a leading slash, passing them data:
+//
+//	{{include "_header.html" .}}
+//
+// Included files are recorded as dependencies of dst so changing them
+// reloads the page.
+func (m *Handler) parseTemplate(fs afero.Fs, dst, fsPath string, src []byte, depth int) (*template.Template, error) {
+	return template.New(path.Base(fsPath)).Funcs(template.FuncMap{
+		"include": func(name string, data ...interface{}) (template.HTML, error) {
+			if depth >= maxIncludeDepth {
+				return "", fmt.Errorf("include %s: nested too deeply", name)
+			}
+			partial := path.Join(path.Dir(fsPath), name)
+			if strings.HasPrefix(name, "/") {
+				partial = path.Join(m.mountForFsPath(fsPath).Dir, name)
+			}
+			m.Fs.Depend(dst, partial)
+			b, err := afero.ReadFile(fs, partial)
+			if err != nil {
+				return "", err
+			}
+			tmpl, err := m.parseTemplate(fs, dst, partial, b, depth+1)
+			if err != nil {
+				return "", err
+			}
+			var d interface{}
+			if len(data) > 0 {
+				d = data[0]
+			}
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, d); err != nil {
+				return "", err
+			}
+			return template.HTML(buf.String()), nil
+		},
+	}).Parse(string(src))
+}
+
+// pageData decodes the data file next to a page with the same name and
+// a data extension, like index.json for index.html.
+func (m *Handler) pageData(fsPath string) (interface{}, error) {
+	base := strings.TrimSuffix(fsPath, path.Ext(fsPath))
+	for _, ext := range DataExts {
+		dataPath := base + ext
+		if ok, _ := afero.Exists(m.Fs, dataPath); !ok {
+			continue
+		}
+		m.Fs.Depend(fsPath, dataPath)
+		b, err := afero.ReadFile(m.Fs, dataPath)
+		if err != nil {
+			return nil, err
+		}
+		return decodeData(ext, b)
+	}
+	return nil, nil
+}
+
+// renderPage renders the HTML file at fsPath as a template with its
+// page data, recording the files it uses again.
+func (m *Handler) renderPage(fsPath string) ([]byte, error) {
+	m.Fs.ResetDeps(fsPath)
+	src, err := afero.ReadFile(m.Fs, fsPath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := m.pageData(fsPath)
+	if err != nil {
+		return nil, err
+	}
+	tmpl, err := m.parseTemplate(m.Fs, fsPath, fsPath, src, 0)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (m *Handler) servePage(w http.ResponseWriter, r *http.Request, fsPath string) {
+	fi, err := m.Fs.Stat(fsPath)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	b, err := m.renderPage(fsPath)
+	if err != nil {
+		debug(err)
+		w.Header().Set("content-type", "text/html; charset=utf-8")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write(m.errorPage(fsPath, err))
+		return
+	}
+	w.Header().Set("content-type", "text/html; charset=utf-8")
+	m.addModulePreloads(w, r.URL.Path, b)
+	http.ServeContent(w, r, path.Base(fsPath), fi.ModTime(), bytes.NewReader(b))
+}
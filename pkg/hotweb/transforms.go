@@ -0,0 +1,99 @@
+package hotweb
+
+import (
+	"encoding/json"
+	"path"
+	"strings"
+	"text/template"
+
+	"github.com/progrium/hotweb/pkg/cssmodules"
+	"github.com/progrium/hotweb/pkg/esbuild"
+	"github.com/spf13/afero"
+)
+
+// registerTransforms registers the files made on the fly from others.
+func (m *Handler) registerTransforms() {
+	m.Fs.Register(".js", ".jsx", func(fs afero.Fs, dst, src string) ([]byte, error) {
+		debug("building", dst)
+		// rewrite imports esbuild can't parse, like import attributes,
+		// in an overlay before building
+		b, err := afero.ReadFile(fs, src)
+		if err != nil {
+			return nil, err
+		}
+		overlay := afero.NewCopyOnWriteFs(afero.NewReadOnlyFs(fs), afero.NewMemMapFs())
+		if err := afero.WriteFile(overlay, src, rewriteModuleImports(b), 0644); err != nil {
+			return nil, err
+		}
+		return esbuild.BuildFile(overlay, src)
+	})
+
+	for _, ext := range DataExts {
+		m.Fs.Register(ext+".js", ext, func(fs afero.Fs, dst, src string) ([]byte, error) {
+			debug("building", dst)
+			b, err := afero.ReadFile(fs, src)
+			if err != nil {
+				return nil, err
+			}
+			return buildDataModule(src, b)
+		})
+	}
+
+	m.Fs.Register(".svg.js", ".svg", func(fs afero.Fs, dst, src string) ([]byte, error) {
+		debug("building", dst)
+		b, err := afero.ReadFile(fs, src)
+		if err != nil {
+			return nil, err
+		}
+		return buildSVGModule(esbuild.Factory(), src, b)
+	})
+
+	m.Fs.Register(".svg.url.js", ".svg", func(fs afero.Fs, dst, src string) ([]byte, error) {
+		b, err := afero.ReadFile(fs, src)
+		if err != nil {
+			return nil, err
+		}
+		return buildURLModule(m.urlPath(src), b), nil
+	})
+
+	m.Fs.Register(".html", ".md", func(fs afero.Fs, dst, src string) ([]byte, error) {
+		debug("building", dst)
+		b, err := afero.ReadFile(fs, src)
+		if err != nil {
+			return nil, err
+		}
+		return m.buildMarkdownPage(m.Fs.Building(fs), dst, src, b)
+	})
+
+	m.Fs.Register(".module.js", ".module.css", func(fs afero.Fs, dst, src string) ([]byte, error) {
+		debug("building", dst)
+		b, err := afero.ReadFile(fs, src)
+		if err != nil {
+			return nil, err
+		}
+		return m.buildCSSModule(m.urlPath(src), b)
+	})
+}
+
+// buildCSSModule makes a module injecting a stylesheet with scoped class
+// and id names that default exports the mapping to the scoped names.
+func (m *Handler) buildCSSModule(urlPath string, src []byte) ([]byte, error) {
+	tmpl := template.Must(template.New("cssmodule").Parse(CSSModuleTmpl))
+
+	scoped, names := cssmodules.Scope(absoluteCSSURLs(src, urlPath), urlPath)
+	jsonPath, _ := json.Marshal(urlPath)
+	jsonCSS, _ := json.Marshal(string(scoped))
+	jsonNames, err := json.MarshalIndent(names, "", "\t")
+	if err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	err = tmpl.Execute(&b, map[string]interface{}{
+		"Path":       string(jsonPath),
+		"CSS":        string(jsonCSS),
+		"Names":      string(jsonNames),
+		"ClientPath": path.Join(m.Prefix, InternalPath, ClientFilename),
+	})
+	return []byte(b.String()), err
+}
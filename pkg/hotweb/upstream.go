@@ -0,0 +1,58 @@
+package hotweb
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"path"
+	"strings"
+)
+
+// Proxy forwards requests under a http path to an upstream server.
+type Proxy struct {
+	Path   string // http path prefix to forward
+	Target string // upstream URL, eg http://localhost:3000
+}
+
+type upstream struct {
+	path    string
+	handler http.Handler
+}
+
+func newUpstream(p Proxy) (upstream, error) {
+	target, err := url.Parse(p.Target)
+	if err != nil {
+		return upstream{}, err
+	}
+	if target.Scheme == "" || target.Host == "" {
+		return upstream{}, fmt.Errorf("hotweb: proxy target %q is not an absolute URL", p.Target)
+	}
+	// ReverseProxy passes through WebSocket upgrades as of Go 1.12
+	return upstream{
+		path:    path.Join("/", p.Path),
+		handler: httputil.NewSingleHostReverseProxy(target),
+	}, nil
+}
+
+func (u upstream) match(urlPath string) bool {
+	return u.path == "/" || urlPath == u.path || strings.HasPrefix(urlPath, u.path+"/")
+}
+
+// upstreamFor returns the most specific upstream for a request that
+// hotweb would not otherwise serve from the filesystem.
+func (m *Handler) upstreamFor(r *http.Request) http.Handler {
+	if len(m.upstreams) == 0 || m.matchFile(r) {
+		return nil
+	}
+	var match *upstream
+	for i, u := range m.upstreams {
+		if u.match(r.URL.Path) && (match == nil || len(u.path) > len(match.path)) {
+			match = &m.upstreams[i]
+		}
+	}
+	if match == nil {
+		return nil
+	}
+	return match.handler
+}
@@ -0,0 +1,84 @@
+package hotweb
+
+import (
+	"net/http"
+	"path"
+
+	"github.com/progrium/watcher"
+	"github.com/spf13/afero"
+)
+
+// newLazyWatcher returns a watcher without any files, which are added as
+// they're served. Files created in directories watched after a 404 are
+// changes too.
+func newLazyWatcher(fs afero.Fs) *watcher.Watcher {
+	w, _ := newWriteWatcher(fs)
+	w.FilterOps(watcher.Write, watcher.Create)
+	return w
+}
+
+// statusWriter records the status of a response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// watchServed adds the file served for urlPath to the watcher, or the
+// directory it would be in if it wasn't found.
+func (m *Handler) watchServed(urlPath string, status int) {
+	fsPath, ok := m.fsPath(urlPath)
+	if !ok {
+		return
+	}
+	if status == http.StatusNotFound {
+		m.watchDir(fsPath)
+		return
+	}
+	if fi, err := m.Fs.Fs.Stat(fsPath); err == nil && fi.IsDir() {
+		fsPath = path.Join(fsPath, "index.html")
+	}
+	m.watchFile(fsPath)
+}
+
+// watchFile adds a file and the files it's made from to the watcher.
+func (m *Handler) watchFile(fsPath string) {
+	for _, p := range append([]string{fsPath}, m.Fs.Sources(fsPath)...) {
+		if ok, _ := afero.Exists(m.Fs.Fs, p); ok {
+			m.watch(p)
+		}
+	}
+}
+
+// watchDir adds the nearest existing directory of fsPath in its mount to
+// the watcher so files created in it are noticed.
+func (m *Handler) watchDir(fsPath string) {
+	root := m.mountForFsPath(fsPath).Dir
+	for dir := path.Dir(fsPath); len(dir) >= len(root); dir = path.Dir(dir) {
+		if ok, _ := afero.DirExists(m.Fs.Fs, dir); ok {
+			m.watch(dir)
+			return
+		}
+		if dir == "/" || dir == "." {
+			return
+		}
+	}
+}
+
+// watch adds a path to the watcher once, as adding it again would miss
+// changes made since it was last polled.
+func (m *Handler) watch(fsPath string) {
+	if _, loaded := m.watched.LoadOrStore(fsPath, struct{}{}); loaded {
+		return
+	}
+	if err := m.Watcher.Add(fsPath); err != nil {
+		m.watched.Delete(fsPath)
+		debug(err)
+		return
+	}
+	debug("watching", fsPath)
+}
@@ -117,6 +117,22 @@ func (l *lexer) peekNextWord() string {
 	return ident
 }
 
+// atWord reports whether word is at pos and not part of another word
+// or a property access, eg export but not module.exports.
+func (l *lexer) atWord(word string) bool {
+	if !strings.HasPrefix(l.input[l.pos:], word) {
+		return false
+	}
+	if l.pos > 0 {
+		r, _ := utf8.DecodeLastRuneInString(l.input[:l.pos])
+		if isAlphaNumeric(r) || r == '.' || r == '_' || r == '$' {
+			return false
+		}
+	}
+	r, _ := utf8.DecodeRuneInString(l.input[l.pos+len(word):])
+	return !isAlphaNumeric(r) && r != '_' && r != '$'
+}
+
 func (l *lexer) accept(valid string) bool {
 	if strings.IndexRune(valid, l.next()) >= 0 {
 		return true
@@ -151,7 +167,7 @@ func (l *lexer) errorf(format string, args ...interface{}) stateFn {
 
 func lexText(l *lexer) stateFn {
 	for {
-		if strings.HasPrefix(l.input[l.pos:], "export") {
+		if l.atWord("export") {
 			if l.pos > l.start {
 				l.emit(itemText)
 			}
@@ -216,6 +232,12 @@ func lexInsideExport(l *lexer) stateFn {
 			return lexInsideBraces
 		case isAlphaNumeric(r):
 			l.backup()
+			if l.peekNextWord() == "default" {
+				// the rest of a default export is an expression
+				l.pos += len("default")
+				l.emit(itemKeyword)
+				return lexText
+			}
 			return lexIdentifier(l, lexInsideExport)
 		}
 	}
@@ -306,6 +328,9 @@ func Exports(src []byte) ([]string, error) {
 		if i.typ == itemIdentifier {
 			set[strings.Trim(i.val, "{}()-_;,.$!")] = struct{}{}
 		}
+		if i.typ == itemKeyword && i.val == "default" {
+			set["default"] = struct{}{}
+		}
 		i = l.nextItem()
 	}
 	var exports []string
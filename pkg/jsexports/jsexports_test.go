@@ -0,0 +1,77 @@
+package jsexports
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestExports(t *testing.T) {
+	var tests = []struct {
+		in      string
+		exports []string
+	}{
+		{
+			"export const a = 1;\nexport function b() {}\n",
+			[]string{"a", "b"},
+		},
+		{
+			"export default function() {}\n",
+			[]string{"default"},
+		},
+		{
+			"export default {a: 1, b: 2};\nexport const c = 3;\n",
+			[]string{"c", "default"},
+		},
+		{
+			"const a = 1, b = 2;\nexport { a, b as c };\n",
+			[]string{"a", "c"},
+		},
+		{
+			"module.exports = {a: 1};\nexports.b = 2;\nconst exported = 3;\n",
+			nil,
+		},
+		{
+			"const a=1;export{a};export default a",
+			[]string{"a", "default"},
+		},
+	}
+	for idx, tt := range tests {
+		t.Run(fmt.Sprintf("test%d", idx), func(t *testing.T) {
+			exports, err := Exports([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(exports)
+			if strings.Join(exports, " ") != strings.Join(tt.exports, " ") {
+				t.Errorf("got %v, want %v", exports, tt.exports)
+			}
+		})
+	}
+}
+
+func TestAtWord(t *testing.T) {
+	var tests = []struct {
+		in   string
+		pos  int
+		want bool
+	}{
+		{"export const a", 0, true},
+		{";export{a}", 1, true},
+		{"module.exports = a", 7, false},
+		{"exports.a = 1", 0, false},
+		{"reexport a", 2, false},
+		{"$export a", 1, false},
+		{"export_a = 1", 0, false},
+		{"export", 0, true},
+	}
+	for idx, tt := range tests {
+		t.Run(fmt.Sprintf("test%d", idx), func(t *testing.T) {
+			l := &lexer{input: tt.in, pos: tt.pos}
+			if got := l.atWord("export"); got != tt.want {
+				t.Errorf("got %v, want %v for %q at %d", got, tt.want, tt.in, tt.pos)
+			}
+		})
+	}
+}
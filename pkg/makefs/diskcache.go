@@ -0,0 +1,143 @@
+package makefs
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DiskCache keeps made files in a directory so they don't have to be
+// built again after a restart. Files are keyed by the contents of their
+// source, the transform and options affecting the transforms, and the
+// least recently used files are removed when the cache grows past
+// MaxSize bytes.
+type DiskCache struct {
+	Dir     string
+	MaxSize int64 // zero for no limit
+
+	mu sync.Mutex
+}
+
+func NewDiskCache(dir string, maxSize int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &DiskCache{Dir: dir, MaxSize: maxSize}, nil
+}
+
+// cacheEntry is a made file and the files other than its source it was
+// made using with the hashes of their contents.
+type cacheEntry struct {
+	Deps map[string]string `json:"deps"`
+	b    []byte
+}
+
+func (c *DiskCache) get(key string) (cacheEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	filename := filepath.Join(c.Dir, key)
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return cacheEntry{}, false
+	}
+	r := bufio.NewReader(bytes.NewReader(data))
+	header, err := r.ReadBytes('\n')
+	if err != nil {
+		return cacheEntry{}, false
+	}
+	var e cacheEntry
+	if err := json.Unmarshal(header, &e); err != nil {
+		return cacheEntry{}, false
+	}
+	e.b = data[len(header):]
+	// the modification time orders files for eviction
+	now := time.Now()
+	os.Chtimes(filename, now, now)
+	return e, true
+}
+
+func (c *DiskCache) put(key string, e cacheEntry) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	header, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(c.Dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(append(append(header, '\n'), e.b...))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), filepath.Join(c.Dir, key)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return c.evict()
+}
+
+// evict removes the least recently used files until the cache is no
+// larger than MaxSize.
+func (c *DiskCache) evict() error {
+	if c.MaxSize <= 0 {
+		return nil
+	}
+	files, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		return err
+	}
+	var size int64
+	for _, fi := range files {
+		size += fi.Size()
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+	for _, fi := range files {
+		if size <= c.MaxSize {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.Dir, fi.Name())); err != nil {
+			return err
+		}
+		size -= fi.Size()
+	}
+	return nil
+}
+
+// cacheFormat is part of every cache key so entries written in an older
+// format are never read.
+const cacheFormat = "1"
+
+// cacheKey identifies a file made from srcFile with a transform.
+func cacheKey(options, name, srcFile string, t transform, src []byte) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\n%s\n%s\n%s\n%s\n%s\n", cacheFormat, options, name, srcFile, t.dstExt, t.srcExt)
+	h.Write(src)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// hashFile returns the hash of a file's contents, or an empty string if
+// it can't be read, so creating a missing file changes its hash.
+func hashFile(b []byte, err error) string {
+	if err != nil {
+		return ""
+	}
+	sum := sha1.Sum(b)
+	return hex.EncodeToString(sum[:])
+}
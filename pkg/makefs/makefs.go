@@ -1,9 +1,15 @@
 package makefs
 
 import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
 	"os"
-	"path"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/spf13/afero"
 	"github.com/spf13/afero/mem"
@@ -12,9 +18,36 @@ import (
 type Fs struct {
 	afero.Fs
 	transforms map[string][]transform
+
+	deps   map[string][]string // file to files made using it
+	uses   map[string][]string // made file to files it was made using
+	depsMu sync.Mutex
+
+	made     map[string]made
+	building map[string]*build
+	buildMu  sync.Mutex
+
+	cache        *DiskCache
+	cacheOptions string
+}
+
+type made struct {
+	b       []byte
+	stamp   string
+	modTime time.Time
+}
+
+// build is an in progress build waited on by concurrent opens.
+type build struct {
+	made
+	wg  sync.WaitGroup
+	err error
+
+	waitingOn *build // build this one is waiting for, guarded by buildMu
 }
 
 type transform struct {
+	dstExt string
 	srcExt string
 	fn     transformFn
 }
@@ -28,61 +61,472 @@ func New(readFs, writeFs afero.Fs) *Fs {
 			writeFs,
 		),
 		transforms: make(map[string][]transform),
+		deps:       make(map[string][]string),
+		uses:       make(map[string][]string),
+		made:       make(map[string]made),
+		building:   make(map[string]*build),
 	}
 }
 
 func (f *Fs) Register(dstExt, srcExt string, fn transformFn) {
 	f.transforms[dstExt] = append(f.transforms[dstExt], transform{
+		dstExt: dstExt,
 		srcExt: srcExt,
 		fn:     fn,
 	})
 }
 
-func (f *Fs) ensureTransforms(name string) afero.File {
-	transforms, ok := f.transforms[path.Ext(name)]
-	if !ok {
-		return nil
+// dstExts returns the registered extensions name ends with, longest
+// first so extensions like .module.js take precedence over .js.
+func (f *Fs) dstExts(name string) []string {
+	var exts []string
+	for ext := range f.transforms {
+		if strings.HasSuffix(name, ext) {
+			exts = append(exts, ext)
+		}
+	}
+	sort.Slice(exts, func(i, j int) bool {
+		return len(exts[i]) > len(exts[j])
+	})
+	return exts
+}
+
+// Depend records that the made file dst was made using the file dep, for
+// transforms that read files other than their source, like templates.
+// Files that were looked for but don't exist should be recorded too, so
+// dst is made again when they're created. Dependencies of made files are
+// recorded again each time they're made.
+func (f *Fs) Depend(dst, dep string) {
+	f.depsMu.Lock()
+	defer f.depsMu.Unlock()
+	if !contains(f.deps[dep], dst) {
+		f.deps[dep] = append(f.deps[dep], dst)
+	}
+	if !contains(f.uses[dst], dep) {
+		f.uses[dst] = append(f.uses[dst], dep)
+	}
+}
+
+// Derived returns the names of files transforms make from the file name,
+// including files made using it recorded with Depend.
+func (f *Fs) Derived(name string) []string {
+	f.depsMu.Lock()
+	derived := append([]string{}, f.deps[name]...)
+	f.depsMu.Unlock()
+	for dstExt, transforms := range f.transforms {
+		for _, transform := range transforms {
+			if !strings.HasSuffix(name, transform.srcExt) {
+				continue
+			}
+			dst := strings.TrimSuffix(name, transform.srcExt) + dstExt
+			if dst != name && !contains(derived, dst) {
+				derived = append(derived, dst)
+			}
+		}
+	}
+	sort.Strings(derived)
+	return derived
+}
+
+// Sources returns the files name is made from, the source of its
+// transform and files recorded with Depend, without making it.
+func (f *Fs) Sources(name string) []string {
+	var sources []string
+	if srcExt := f.transformFor(name); srcExt != "" {
+		for _, dstExt := range f.dstExts(name) {
+			if srcFile := strings.TrimSuffix(name, dstExt) + srcExt; srcFile != name {
+				if exists, _ := afero.Exists(f.Fs, srcFile); exists {
+					sources = append(sources, srcFile)
+					break
+				}
+			}
+		}
+	}
+	f.depsMu.Lock()
+	defer f.depsMu.Unlock()
+	for _, dep := range f.uses[name] {
+		if !contains(sources, dep) {
+			sources = append(sources, dep)
+		}
 	}
-	for _, transform := range transforms {
-		srcFile := strings.ReplaceAll(name, path.Ext(name), transform.srcExt)
+	return sources
+}
+
+// UseCache keeps made files in a disk cache. Options should identify
+// anything other than their source that changes what transforms make.
+func (f *Fs) UseCache(cache *DiskCache, options string) {
+	f.cache = cache
+	f.cacheOptions = options
+}
+
+// Made reports whether name is a file made by a transform rather than
+// a file in the underlying filesystem.
+func (f *Fs) Made(name string) bool {
+	return f.transformFor(name) != ""
+}
+
+// Building returns a filesystem that makes files like f for a transform
+// given fs, so it can read other made files while building. A file that
+// depends on itself is an error instead of waiting on its own build. For
+// any other fs it returns f.
+func (f *Fs) Building(fs afero.Fs) afero.Fs {
+	if b, ok := fs.(*buildFs); ok {
+		return &stackFs{Fs: f, stack: b.stack}
+	}
+	return f
+}
+
+// buildFs is the filesystem transforms are given, the underlying
+// filesystem along with the files being built.
+type buildFs struct {
+	afero.Fs
+	stack []string
+}
+
+// stackFs makes files as part of building the files in stack.
+type stackFs struct {
+	*Fs
+	stack []string
+}
+
+func (s *stackFs) Open(name string) (afero.File, error) {
+	tf, err := s.ensureTransforms(name, s.stack)
+	if err != nil || tf != nil {
+		return tf, err
+	}
+	return s.Fs.Fs.Open(name)
+}
+
+func (s *stackFs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
+	tf, err := s.ensureTransforms(name, s.stack)
+	if err != nil || tf != nil {
+		return tf, err
+	}
+	return s.Fs.Fs.OpenFile(name, flag, perm)
+}
+
+func (s *stackFs) Stat(name string) (os.FileInfo, error) {
+	tf, err := s.ensureTransforms(name, s.stack)
+	if err != nil {
+		return nil, err
+	}
+	if tf != nil {
+		return tf.Stat()
+	}
+	return s.Fs.Fs.Stat(name)
+}
+
+func (f *Fs) ensureTransforms(name string, stack []string) (afero.File, error) {
+	for _, dstExt := range f.dstExts(name) {
+		tf, err := f.ensureTransform(name, dstExt, stack)
+		if err != nil || tf != nil {
+			return tf, err
+		}
+	}
+	return nil, nil
+}
+
+func (f *Fs) ensureTransform(name, dstExt string, stack []string) (afero.File, error) {
+	for _, transform := range f.transforms[dstExt] {
+		srcFile := strings.TrimSuffix(name, dstExt) + transform.srcExt
 		srcExists, err := afero.Exists(f.Fs, srcFile)
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		if srcExists {
-			b, err := transform.fn(f.Fs, name, srcFile)
+			m, err := f.make(name, srcFile, transform, stack)
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
-			f := mem.NewFileHandle(mem.CreateFile(name))
-			_, err = f.Write(b)
+			fd := mem.CreateFile(name)
+			f := mem.NewFileHandle(fd)
+			_, err = f.Write(m.b)
 			if err != nil {
-				panic(err)
+				return nil, err
 			}
+			mem.SetModTime(fd, m.modTime)
 			f.Seek(0, 0)
-			return f
+			return f, nil
+		}
+	}
+	return nil, nil
+}
+
+// make returns the file name made from srcFile, building it unless the
+// source and the files it depends on are unchanged since the last build.
+// Concurrent builds of the same file share one build. Stack is the files
+// being built that name is being made for.
+func (f *Fs) make(name, srcFile string, t transform, stack []string) (made, error) {
+	stamp := f.stamp(name, srcFile)
+	f.buildMu.Lock()
+	if m, ok := f.made[name]; ok && m.stamp == stamp {
+		f.buildMu.Unlock()
+		return m, nil
+	}
+	var caller *build
+	if len(stack) > 0 {
+		caller = f.building[stack[len(stack)-1]]
+	}
+	if b, ok := f.building[name]; ok {
+		// waiting on a build that waits on this one would never end
+		for w := b; w != nil && caller != nil; w = w.waitingOn {
+			if w == caller {
+				f.buildMu.Unlock()
+				return made{}, fmt.Errorf("makefs: %s depends on itself: %s", name, strings.Join(append(stack, name), " -> "))
+			}
+		}
+		f.wait(caller, b)
+		return b.made, b.err
+	}
+	b := &build{err: fmt.Errorf("makefs: building %s failed", name)}
+	b.wg.Add(1)
+	f.building[name] = b
+	if caller != nil {
+		caller.waitingOn = b
+	}
+	f.buildMu.Unlock()
+
+	defer func() {
+		f.buildMu.Lock()
+		delete(f.building, name)
+		if b.err == nil {
+			f.made[name] = b.made
+		}
+		if caller != nil {
+			caller.waitingOn = nil
+		}
+		f.buildMu.Unlock()
+		b.wg.Done()
+	}()
+	f.ResetDeps(name)
+	key := f.cacheKey(name, srcFile, t)
+	if out, ok := f.cached(key, name); ok {
+		b.made, b.err = made{b: out, stamp: f.stamp(name, srcFile), modTime: time.Now()}, nil
+		return b.made, nil
+	}
+	fs := &buildFs{Fs: f.Fs, stack: append(stack[:len(stack):len(stack)], name)}
+	out, err := t.fn(fs, name, srcFile)
+	// dependencies are recorded while building
+	b.made, b.err = made{b: out, stamp: f.stamp(name, srcFile), modTime: time.Now()}, err
+	if err == nil {
+		f.store(key, name, out)
+	}
+	return b.made, b.err
+}
+
+// ResetDeps forgets the files name was made using before making it again.
+// Made files are reset automatically, files made outside of transforms
+// that record dependencies with Depend should reset them first.
+func (f *Fs) ResetDeps(name string) {
+	f.depsMu.Lock()
+	defer f.depsMu.Unlock()
+	for _, dep := range f.uses[name] {
+		var dsts []string
+		for _, dst := range f.deps[dep] {
+			if dst != name {
+				dsts = append(dsts, dst)
+			}
+		}
+		if len(dsts) == 0 {
+			delete(f.deps, dep)
+		} else {
+			f.deps[dep] = dsts
+		}
+	}
+	delete(f.uses, name)
+}
+
+// wait waits for the build b with buildMu held, recording that caller is
+// waiting on it.
+func (f *Fs) wait(caller, b *build) {
+	if caller != nil {
+		caller.waitingOn = b
+	}
+	f.buildMu.Unlock()
+	b.wg.Wait()
+	if caller != nil {
+		f.buildMu.Lock()
+		caller.waitingOn = nil
+		f.buildMu.Unlock()
+	}
+}
+
+// cacheKey returns the disk cache key of the file name made from srcFile,
+// or an empty string if there is no disk cache.
+func (f *Fs) cacheKey(name, srcFile string, t transform) string {
+	if f.cache == nil {
+		return ""
+	}
+	src, err := afero.ReadFile(f.Fs, srcFile)
+	if err != nil {
+		return ""
+	}
+	return cacheKey(f.cacheOptions, name, srcFile, t, src)
+}
+
+// cached returns a made file from the disk cache if the files it was
+// made using are unchanged, recording them with Depend.
+func (f *Fs) cached(key, name string) ([]byte, bool) {
+	if key == "" {
+		return nil, false
+	}
+	e, ok := f.cache.get(key)
+	if !ok {
+		return nil, false
+	}
+	for dep, hash := range e.Deps {
+		if hashFile(afero.ReadFile(f.Fs, dep)) != hash {
+			return nil, false
 		}
 	}
-	return nil
+	for dep := range e.Deps {
+		f.Depend(name, dep)
+	}
+	return e.b, true
+}
+
+func (f *Fs) store(key, name string, out []byte) {
+	if key == "" {
+		return
+	}
+	e := cacheEntry{Deps: make(map[string]string), b: out}
+	f.depsMu.Lock()
+	deps := append([]string{}, f.uses[name]...)
+	f.depsMu.Unlock()
+	for _, dep := range deps {
+		e.Deps[dep] = hashFile(afero.ReadFile(f.Fs, dep))
+	}
+	if err := f.cache.put(key, e); err != nil {
+		log.Println("makefs:", err)
+	}
+}
+
+// stamp identifies the contents of the source of a made file and the
+// files it was made using.
+func (f *Fs) stamp(name, srcFile string) string {
+	f.depsMu.Lock()
+	files := append([]string{srcFile}, f.uses[name]...)
+	f.depsMu.Unlock()
+	h := sha1.New()
+	for _, file := range files {
+		b, err := afero.ReadFile(f.Fs, file)
+		if err != nil {
+			b = nil
+		}
+		fmt.Fprintf(h, "%s %d\n", file, len(b))
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Warm builds the files made from names in parallel with the given
+// number of workers so they don't have to be built when first opened.
+// Build errors are returned for each file that failed.
+func (f *Fs) Warm(names []string, workers int) map[string]error {
+	if workers < 1 {
+		workers = 1
+	}
+	type job struct {
+		name, srcFile string
+		t             transform
+	}
+	jobs := make(chan job)
+	errs := make(map[string]error)
+	var errsMu sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				if _, err := f.tryMake(j.name, j.srcFile, j.t); err != nil {
+					errsMu.Lock()
+					errs[j.name] = err
+					errsMu.Unlock()
+				}
+			}
+		}()
+	}
+	for _, name := range names {
+		for dstExt, transforms := range f.transforms {
+			for _, t := range transforms {
+				if !strings.HasSuffix(name, t.srcExt) {
+					continue
+				}
+				dst := strings.TrimSuffix(name, t.srcExt) + dstExt
+				// only build files that would be made from name
+				if dst != name && f.transformFor(dst) == t.srcExt {
+					jobs <- job{dst, name, t}
+				}
+			}
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	return errs
+}
+
+// tryMake is make for use outside of requests, turning panics in
+// transforms into errors.
+func (f *Fs) tryMake(name, srcFile string, t transform) (m made, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("makefs: building %s: %v", name, r)
+		}
+	}()
+	return f.make(name, srcFile, t, nil)
+}
+
+// transformFor returns the source extension of the transform name is
+// made with, or an empty string.
+func (f *Fs) transformFor(name string) string {
+	for _, dstExt := range f.dstExts(name) {
+		for _, t := range f.transforms[dstExt] {
+			srcFile := strings.TrimSuffix(name, dstExt) + t.srcExt
+			if exists, _ := afero.Exists(f.Fs, srcFile); exists {
+				return t.srcExt
+			}
+		}
+	}
+	return ""
+}
+
+// mustEnsureTransforms makes name if it's made by a transform, panicking
+// if it fails.
+func (f *Fs) mustEnsureTransforms(name string) afero.File {
+	tf, err := f.ensureTransforms(name, nil)
+	if err != nil {
+		panic(err)
+	}
+	return tf
 }
 
 func (f *Fs) Open(name string) (afero.File, error) {
-	if tf := f.ensureTransforms(name); tf != nil {
+	if tf := f.mustEnsureTransforms(name); tf != nil {
 		return tf, nil
 	}
 	return f.Fs.Open(name)
 }
 
 func (f *Fs) OpenFile(name string, flag int, perm os.FileMode) (afero.File, error) {
-	if tf := f.ensureTransforms(name); tf != nil {
+	if tf := f.mustEnsureTransforms(name); tf != nil {
 		return tf, nil
 	}
 	return f.Fs.OpenFile(name, flag, perm)
 }
 
 func (f *Fs) Stat(name string) (os.FileInfo, error) {
-	if tf := f.ensureTransforms(name); tf != nil {
+	if tf := f.mustEnsureTransforms(name); tf != nil {
 		return tf.Stat()
 	}
 	return f.Fs.Stat(name)
 }
+
+func contains(s []string, e string) bool {
+	for _, a := range s {
+		if a == e {
+			return true
+		}
+	}
+	return false
+}
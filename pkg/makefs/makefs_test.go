@@ -2,7 +2,13 @@ package makefs
 
 import (
 	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/progrium/hotweb/pkg/esbuild"
 	"github.com/spf13/afero"
@@ -43,4 +49,278 @@ func TestMakefs(t *testing.T) {
 			t.Errorf("got %q, want %q", got, existFile)
 		}
 	})
+	t.Run("derived files", func(t *testing.T) {
+		got := mfs.Derived("lib/html.jsx")
+		if len(got) != 1 || got[0] != "lib/html.js" {
+			t.Errorf("got %q, want %q", got, []string{"lib/html.js"})
+		}
+	})
+
+	t.Run("dependent files", func(t *testing.T) {
+		mfs.Depend("lib/html.js", "lib/layout.jsx")
+		got := mfs.Derived("lib/layout.jsx")
+		if len(got) != 2 || got[0] != "lib/html.js" || got[1] != "lib/layout.js" {
+			t.Errorf("got %q, want %q", got, []string{"lib/html.js", "lib/layout.js"})
+		}
+	})
+	t.Run("made files", func(t *testing.T) {
+		if !mfs.Made("html.js") {
+			t.Error("html.js not made")
+		}
+		if mfs.Made("exists.js") {
+			t.Error("exists.js made")
+		}
+	})
+	t.Run("sources", func(t *testing.T) {
+		mfs.Depend("html.js", "layout.jsx")
+		got := mfs.Sources("html.js")
+		if len(got) != 2 || got[0] != "html.jsx" || got[1] != "layout.jsx" {
+			t.Errorf("got %q, want %q", got, []string{"html.jsx", "layout.jsx"})
+		}
+		if got := mfs.Sources("exists.js"); len(got) != 0 {
+			t.Errorf("got %q, want no sources", got)
+		}
+	})
+	var builds int32
+	if err := afero.WriteFile(f, "slow.src", []byte("slow"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	mfs.Register(".out", ".src", func(fs afero.Fs, dst, src string) ([]byte, error) {
+		atomic.AddInt32(&builds, 1)
+		time.Sleep(10 * time.Millisecond)
+		return afero.ReadFile(fs, src)
+	})
+
+	t.Run("concurrent builds", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				got, err := afero.ReadFile(mfs, "slow.out")
+				if err != nil || string(got) != "slow" {
+					t.Errorf("got %q, %v", got, err)
+				}
+			}()
+		}
+		wg.Wait()
+		if n := atomic.LoadInt32(&builds); n != 1 {
+			t.Errorf("got %d builds, want 1", n)
+		}
+	})
+
+	t.Run("changed source", func(t *testing.T) {
+		if err := afero.WriteFile(f, "slow.src", []byte("slower"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := afero.ReadFile(mfs, "slow.out")
+		if err != nil || string(got) != "slower" {
+			t.Errorf("got %q, %v", got, err)
+		}
+		if n := atomic.LoadInt32(&builds); n != 2 {
+			t.Errorf("got %d builds, want 2", n)
+		}
+	})
+
+	t.Run("warm", func(t *testing.T) {
+		if err := afero.WriteFile(f, "warm.src", []byte("warm"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if errs := mfs.Warm([]string{"warm.src", "slow.src", "exists.js"}, 2); len(errs) != 0 {
+			t.Fatal(errs)
+		}
+		if n := atomic.LoadInt32(&builds); n != 3 {
+			t.Errorf("got %d builds, want 3", n)
+		}
+		if _, err := afero.ReadFile(mfs, "warm.out"); err != nil {
+			t.Fatal(err)
+		}
+		if n := atomic.LoadInt32(&builds); n != 3 {
+			t.Errorf("got %d builds after warm, want 3", n)
+		}
+	})
+}
+
+func TestDependencyCycle(t *testing.T) {
+	f := afero.NewMemMapFs()
+	for name, dep := range map[string]string{"a.page": "b.html", "b.page": "a.html", "c.page": "c.html"} {
+		if err := afero.WriteFile(f, name, []byte(dep), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mfs := New(f, afero.NewMemMapFs())
+	// pages include the page named in their source
+	mfs.Register(".html", ".page", func(fs afero.Fs, dst, src string) ([]byte, error) {
+		dep, err := afero.ReadFile(fs, src)
+		if err != nil {
+			return nil, err
+		}
+		return afero.ReadFile(mfs.Building(fs), string(dep))
+	})
+
+	for _, tc := range []struct {
+		name    string
+		names   []string
+		workers int
+	}{
+		{"self", []string{"c.page"}, 1},
+		{"indirect", []string{"a.page"}, 1},
+		{"concurrent", []string{"a.page", "b.page"}, 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			done := make(chan map[string]error)
+			go func() {
+				done <- mfs.Warm(tc.names, tc.workers)
+			}()
+			select {
+			case errs := <-done:
+				if len(errs) != len(tc.names) {
+					t.Fatalf("got %v want errors for %v", errs, tc.names)
+				}
+				for name, err := range errs {
+					if !strings.Contains(err.Error(), "depends on itself") {
+						t.Errorf("%s: got %v want dependency cycle", name, err)
+					}
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("deadlocked building dependency cycle")
+			}
+		})
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "makefs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache, err := NewDiskCache(dir, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := afero.NewMemMapFs()
+	if err := afero.WriteFile(f, "page.src", []byte("page"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := afero.WriteFile(f, "layout.txt", []byte("layout"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	var builds int
+	newFs := func(options string) *Fs {
+		mfs := New(f, afero.NewMemMapFs())
+		mfs.UseCache(cache, options)
+		mfs.Register(".out", ".src", func(fs afero.Fs, dst, src string) ([]byte, error) {
+			builds++
+			b, err := afero.ReadFile(fs, src)
+			if err != nil {
+				return nil, err
+			}
+			if string(b) != "no layout" {
+				mfs.Depend(dst, "layout.txt")
+			}
+			// an optional file, used if it exists
+			mfs.Depend(dst, "extra.txt")
+			if extra, err := afero.ReadFile(fs, "extra.txt"); err == nil {
+				b = append(b, extra...)
+			}
+			return b, nil
+		})
+		return mfs
+	}
+
+	t.Run("restart", func(t *testing.T) {
+		var mfs *Fs
+		for i := 0; i < 2; i++ {
+			mfs = newFs("a")
+			got, err := afero.ReadFile(mfs, "page.out")
+			if err != nil || string(got) != "page" {
+				t.Fatalf("got %q, %v", got, err)
+			}
+		}
+		if builds != 1 {
+			t.Errorf("got %d builds, want 1", builds)
+		}
+		if got := mfs.Derived("layout.txt"); len(got) != 1 || got[0] != "page.out" {
+			t.Errorf("got %q, want dependency restored", got)
+		}
+	})
+
+	t.Run("changed options", func(t *testing.T) {
+		if _, err := afero.ReadFile(newFs("b"), "page.out"); err != nil {
+			t.Fatal(err)
+		}
+		if builds != 2 {
+			t.Errorf("got %d builds, want 2", builds)
+		}
+	})
+
+	t.Run("changed dependency", func(t *testing.T) {
+		if err := afero.WriteFile(f, "layout.txt", []byte("new layout"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := afero.ReadFile(newFs("a"), "page.out"); err != nil {
+			t.Fatal(err)
+		}
+		if builds != 3 {
+			t.Errorf("got %d builds, want 3", builds)
+		}
+	})
+
+	t.Run("eviction", func(t *testing.T) {
+		cache.MaxSize = 1
+		if err := afero.WriteFile(f, "page.src", []byte("changed"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := afero.ReadFile(newFs("a"), "page.out"); err != nil {
+			t.Fatal(err)
+		}
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(files) != 0 {
+			t.Errorf("got %d cached files, want 0", len(files))
+		}
+	})
+	t.Run("missing optional file", func(t *testing.T) {
+		cache.MaxSize = 0
+		if _, err := afero.ReadFile(newFs("a"), "page.out"); err != nil {
+			t.Fatal(err)
+		}
+		before := builds
+		if err := afero.WriteFile(f, "extra.txt", []byte(" extra"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		got, err := afero.ReadFile(newFs("a"), "page.out")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != "changed extra" || builds != before+1 {
+			t.Errorf("got %q after %d builds, want rebuild with optional file", got, builds-before)
+		}
+	})
+
+	t.Run("unused dependency", func(t *testing.T) {
+		mfs := newFs("a")
+		if _, err := afero.ReadFile(mfs, "page.out"); err != nil {
+			t.Fatal(err)
+		}
+		if got := mfs.Derived("layout.txt"); len(got) != 1 {
+			t.Fatalf("got %q, want layout dependent", got)
+		}
+		if err := afero.WriteFile(f, "page.src", []byte("no layout"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := afero.ReadFile(mfs, "page.out"); err != nil {
+			t.Fatal(err)
+		}
+		if got := mfs.Derived("layout.txt"); len(got) != 0 {
+			t.Errorf("got %q, want dependency removed by rebuild", got)
+		}
+		if got := mfs.Sources("page.out"); len(got) != 2 || got[1] != "extra.txt" {
+			t.Errorf("got %q, want source and extra.txt", got)
+		}
+	})
 }
@@ -0,0 +1,64 @@
+package markdown
+
+import (
+	"bytes"
+	"html/template"
+
+	"github.com/yuin/goldmark"
+	"gopkg.in/yaml.v3"
+)
+
+// Page is a Markdown document rendered to HTML.
+type Page struct {
+	Meta    map[string]interface{} // front matter
+	Content template.HTML
+}
+
+// Title returns the title set in the front matter, or fallback.
+func (p Page) Title(fallback string) string {
+	if title, ok := p.Meta["title"].(string); ok && title != "" {
+		return title
+	}
+	return fallback
+}
+
+// Parse renders a CommonMark document with optional YAML front matter
+// delimited by --- lines.
+func Parse(src []byte) (Page, error) {
+	page := Page{Meta: make(map[string]interface{})}
+	meta, body := splitFrontMatter(src)
+	if meta != nil {
+		if err := yaml.Unmarshal(meta, &page.Meta); err != nil {
+			return page, err
+		}
+		if page.Meta == nil {
+			page.Meta = make(map[string]interface{})
+		}
+	}
+	var buf bytes.Buffer
+	if err := goldmark.Convert(body, &buf); err != nil {
+		return page, err
+	}
+	page.Content = template.HTML(buf.String())
+	return page, nil
+}
+
+func splitFrontMatter(src []byte) ([]byte, []byte) {
+	src = bytes.TrimPrefix(src, []byte("\ufeff"))
+	normalized := bytes.ReplaceAll(src, []byte("\r\n"), []byte("\n"))
+	if !bytes.HasPrefix(normalized, []byte("---\n")) {
+		return nil, src
+	}
+	rest := normalized[4:]
+	if bytes.HasPrefix(rest, []byte("---\n")) {
+		return []byte{}, rest[4:]
+	}
+	end := bytes.Index(rest, []byte("\n---\n"))
+	if end < 0 {
+		if bytes.HasSuffix(rest, []byte("\n---")) {
+			return rest[:len(rest)-4], nil
+		}
+		return nil, src
+	}
+	return rest[:end], rest[end+5:]
+}
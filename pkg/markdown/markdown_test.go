@@ -0,0 +1,44 @@
+package markdown
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	var tests = []struct {
+		in      string
+		title   string
+		content string
+	}{
+		{
+			"# Hello\n",
+			"fallback",
+			"<h1>Hello</h1>\n",
+		},
+		{
+			"---\ntitle: Docs\nlayout: _docs.html\n---\nSome *text*\n",
+			"Docs",
+			"<p>Some <em>text</em></p>\n",
+		},
+		{
+			"---\n---\n---\n",
+			"fallback",
+			"<hr>\n",
+		},
+	}
+	for idx, tt := range tests {
+		t.Run(fmt.Sprintf("test%d", idx), func(t *testing.T) {
+			page, err := Parse([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := page.Title("fallback"); got != tt.title {
+				t.Errorf("got title %q, want %q", got, tt.title)
+			}
+			if string(page.Content) != tt.content {
+				t.Errorf("got %q, want %q", page.Content, tt.content)
+			}
+		})
+	}
+}